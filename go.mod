@@ -2,4 +2,17 @@ module github.com/ncarlier/imgcast
 
 go 1.24.0
 
-require golang.org/x/crypto v0.47.0
+require (
+	github.com/eclipse/paho.mqtt.golang v1.5.1
+	github.com/fsnotify/fsnotify v1.10.1
+	golang.org/x/crypto v0.47.0
+	golang.org/x/image v0.36.0
+	golang.org/x/sync v0.19.0
+)
+
+require (
+	github.com/gorilla/websocket v1.5.3 // indirect
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sys v0.40.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+)
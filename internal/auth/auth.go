@@ -2,6 +2,7 @@ package auth
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -9,6 +10,13 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	// ErrInvalidUsername is returned when a username cannot be stored in a htpasswd file
+	ErrInvalidUsername = errors.New("invalid username")
+	// ErrUserNotFound is returned when removing a user missing from a htpasswd file
+	ErrUserNotFound = errors.New("user not found")
+)
+
 // Authenticator handles authentication via htpasswd files
 type Authenticator struct {
 	htpasswdPath string
@@ -70,26 +78,101 @@ func (a *Authenticator) Exists() bool {
 	return err == nil
 }
 
-// CreateWithUser creates a new htpasswd file with the given user
-func (a *Authenticator) CreateWithUser(username, password string) error {
-	// Hash the password with bcrypt
+// Create creates an empty htpasswd file if it does not exist yet
+func (a *Authenticator) Create() error {
+	file, err := os.OpenFile(a.htpasswdPath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create htpasswd file: %w", err)
+	}
+	return file.Close()
+}
+
+// ValidateUsername checks that a username can be stored in an htpasswd file
+func ValidateUsername(username string) error {
+	if username == "" || strings.ContainsAny(username, ":\n") {
+		return fmt.Errorf("%w: %q", ErrInvalidUsername, username)
+	}
+	return nil
+}
+
+// SetUser adds a user to the htpasswd file or updates its password if it already exists
+func (a *Authenticator) SetUser(username, password string) error {
+	if err := ValidateUsername(username); err != nil {
+		return err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// Create the htpasswd file
-	file, err := os.Create(a.htpasswdPath)
+	lines, err := a.readLines()
 	if err != nil {
-		return fmt.Errorf("failed to create htpasswd file: %w", err)
+		return err
+	}
+
+	entry := fmt.Sprintf("%s:%s", username, hashedPassword)
+	replaced := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, username+":") {
+			lines[i] = entry
+			replaced = true
+		}
+	}
+	if !replaced {
+		lines = append(lines, entry)
 	}
-	defer file.Close()
 
-	// Write the user entry
-	_, err = fmt.Fprintf(file, "%s:%s\n", username, hashedPassword)
+	return a.writeLines(lines)
+}
+
+// RemoveUser removes a user from the htpasswd file
+func (a *Authenticator) RemoveUser(username string) error {
+	lines, err := a.readLines()
 	if err != nil {
-		return fmt.Errorf("failed to write htpasswd entry: %w", err)
+		return err
+	}
+
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, username+":") {
+			kept = append(kept, line)
+		}
 	}
+	if len(kept) == len(lines) {
+		return fmt.Errorf("%w: %q", ErrUserNotFound, username)
+	}
+
+	return a.writeLines(kept)
+}
 
+// readLines returns the raw lines of the htpasswd file
+func (a *Authenticator) readLines() ([]string, error) {
+	content, err := os.ReadFile(a.htpasswdPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// writeLines replaces the content of the htpasswd file with the given lines
+func (a *Authenticator) writeLines(lines []string) error {
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.WriteFile(a.htpasswdPath, []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write htpasswd file: %w", err)
+	}
 	return nil
 }
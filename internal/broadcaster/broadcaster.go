@@ -11,12 +11,20 @@ import (
 type Client struct {
 	w       http.ResponseWriter
 	flusher http.Flusher
+	done    chan struct{}
+}
+
+// Done returns a channel closed when the broadcaster terminates the client stream
+func (c *Client) Done() <-chan struct{} {
+	return c.done
 }
 
 // Broadcaster manages SSE connections and broadcasts messages to clients
 type Broadcaster struct {
 	clients   map[*Client]bool
-	broadcast chan struct{}
+	broadcast chan string
+	quit      chan struct{}
+	closed    bool
 	mu        sync.Mutex
 }
 
@@ -24,7 +32,8 @@ type Broadcaster struct {
 func New() *Broadcaster {
 	b := &Broadcaster{
 		clients:   make(map[*Client]bool),
-		broadcast: make(chan struct{}, 10),
+		broadcast: make(chan string, 10),
+		quit:      make(chan struct{}),
 	}
 	go b.run()
 	return b
@@ -33,19 +42,23 @@ func New() *Broadcaster {
 // run is the broadcaster's main loop that listens for broadcast events
 func (b *Broadcaster) run() {
 	for {
-		<-b.broadcast
-		b.sendToClients()
+		select {
+		case message := <-b.broadcast:
+			b.sendToClients(message)
+		case <-b.quit:
+			return
+		}
 	}
 }
 
-// sendToClients sends the "updated" message to all connected clients
-func (b *Broadcaster) sendToClients() {
+// sendToClients sends a message to all connected clients
+func (b *Broadcaster) sendToClients(message string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	slog.Info("Broadcasting update to clients", "count", len(b.clients))
+	slog.Info("Broadcasting message to clients", "message", message, "count", len(b.clients))
 	for client := range b.clients {
-		_, err := fmt.Fprintf(client.w, "data: updated\n\n")
+		_, err := fmt.Fprintf(client.w, "data: %s\n\n", message)
 		if err != nil {
 			delete(b.clients, client)
 			continue
@@ -59,9 +72,15 @@ func (b *Broadcaster) AddClient(w http.ResponseWriter, flusher http.Flusher) *Cl
 	client := &Client{
 		w:       w,
 		flusher: flusher,
+		done:    make(chan struct{}),
 	}
 
 	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(client.done)
+		return client
+	}
 	b.clients[client] = true
 	b.mu.Unlock()
 
@@ -79,10 +98,25 @@ func (b *Broadcaster) RemoveClient(client *Client) {
 	b.mu.Unlock()
 }
 
-// Notify sends a broadcast event to all connected clients
+// Notify sends the "updated" message to all connected clients
 func (b *Broadcaster) Notify() {
+	b.Send("updated")
+}
+
+// NotifySlot sends the "updated:{slot}" message to all connected clients
+// The empty slot designates the main room image and sends the "updated" message
+func (b *Broadcaster) NotifySlot(slot string) {
+	if slot == "" {
+		b.Notify()
+		return
+	}
+	b.Send("updated:" + slot)
+}
+
+// Send broadcasts a message to all connected clients
+func (b *Broadcaster) Send(message string) {
 	select {
-	case b.broadcast <- struct{}{}:
+	case b.broadcast <- message:
 	default:
 		// Channel full, skip this notification
 		slog.Warn("Broadcast channel full, skipping notification")
@@ -95,3 +129,24 @@ func (b *Broadcaster) ClientCount() int {
 	defer b.mu.Unlock()
 	return len(b.clients)
 }
+
+// Close sends the "deleted" message to all connected clients, terminates their streams and stops the broadcaster
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.quit)
+
+	slog.Info("Closing broadcaster", "count", len(b.clients))
+	for client := range b.clients {
+		if _, err := fmt.Fprintf(client.w, "data: deleted\n\n"); err == nil {
+			client.flusher.Flush()
+		}
+		close(client.done)
+		delete(b.clients, client)
+	}
+}
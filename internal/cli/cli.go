@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/ncarlier/imgcast/internal/auth"
+	"github.com/ncarlier/imgcast/internal/config"
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/internal/storage"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+// Exit codes returned by commands
+const (
+	// ExitOK is returned when the command succeeds
+	ExitOK = 0
+	// ExitError is returned when the command fails
+	ExitError = 1
+	// ExitUsage is returned when the command line is invalid
+	ExitUsage = 2
+	// ExitNotFound is returned when the room, or another resource designated by the command, does not exist
+	ExitNotFound = 3
+	// ExitConflict is returned when the resource to create already exists, or cannot be changed in its current state
+	ExitConflict = 4
+	// ExitUnauthorized is returned when the server rejects the credentials
+	ExitUnauthorized = 5
+	// ExitUnavailable is returned when the server cannot be reached or fails
+	ExitUnavailable = 6
+)
+
+// command is a command-line subcommand
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+// commands holds the available subcommands by name
+var commands = map[string]command{
+	"room":      {usage: "manage rooms (create, list, delete, gc)", run: runRoom},
+	"namespace": {usage: "manage room namespaces (create, delete)", run: runNamespace},
+	"user":      {usage: "manage room members (add, remove)", run: runUser},
+	"token":     {usage: "manage room ingestion tokens (create)", run: runToken},
+	"push":      {usage: "upload an image to a room", run: runPush},
+	"watch":     {usage: "upload the images written to a directory to a room", run: runWatch},
+}
+
+// Run executes the subcommand designated by the first argument and returns the process exit code
+func Run(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return ExitUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		printUsage()
+		return ExitUsage
+	}
+
+	return cmd.run(args[1:])
+}
+
+// printUsage prints the list of available subcommands
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: imgcast [command] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nWithout command, the server is started.\n\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+// fail prints an error and returns the exit code matching it
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	return exitCode(err)
+}
+
+// exitCode returns the exit code matching an error
+func exitCode(err error) int {
+	var srvErr *serverError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &srvErr):
+		switch {
+		case srvErr.status == http.StatusUnauthorized || srvErr.status == http.StatusForbidden:
+			return ExitUnauthorized
+		case srvErr.status == http.StatusNotFound:
+			return ExitNotFound
+		case srvErr.status == http.StatusConflict:
+			return ExitConflict
+		case srvErr.status >= 500:
+			return ExitUnavailable
+		default:
+			return ExitError
+		}
+	case errors.As(err, &urlErr):
+		return ExitUnavailable
+	case errors.Is(err, room.ErrRoomNotFound), errors.Is(err, room.ErrNamespaceNotFound), errors.Is(err, room.ErrTokenNotFound),
+		errors.Is(err, auth.ErrUserNotFound), errors.Is(err, os.ErrNotExist):
+		return ExitNotFound
+	case errors.Is(err, room.ErrRoomExists), errors.Is(err, room.ErrNamespaceNotEmpty), errors.Is(err, room.ErrTokensFull):
+		return ExitConflict
+	case errors.Is(err, room.ErrUnauthorized):
+		return ExitUnauthorized
+	case errors.Is(err, validator.ErrInvalidRoomName), errors.Is(err, validator.ErrInvalidSlotName), errors.Is(err, room.ErrInvalidSettings),
+		errors.Is(err, room.ErrInvalidToken), errors.Is(err, auth.ErrInvalidUsername), errors.Is(err, errMissingCredentials):
+		return ExitUsage
+	default:
+		return ExitError
+	}
+}
+
+// newLocalManager creates a room manager operating directly on the configured rooms directory
+func newLocalManager() (*room.Manager, error) {
+	cfg := config.Load()
+	store := storage.New(cfg.RoomsBaseDir)
+	if err := store.EnsureBaseDir(); err != nil {
+		return nil, err
+	}
+	manager := room.NewManager(store, auth.NewAuthenticator(cfg.AdminHtpasswd), false, cfg.ThumbnailSizes)
+	if err := manager.MigrateRoomNames(); err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/config"
+)
+
+// requestTimeout is the maximum duration of an API request
+const requestTimeout = 30 * time.Second
+
+// errMissingCredentials is returned when calling the server without credentials
+var errMissingCredentials = errors.New("server credentials required (-user user:password or $IMGCAST_USER)")
+
+// serverError is a request refused by the server
+type serverError struct {
+	status  int
+	message string
+}
+
+// Error returns the status and the message of the server response
+func (e *serverError) Error() string {
+	return fmt.Sprintf("server responded %d %s: %s", e.status, http.StatusText(e.status), e.message)
+}
+
+// temporary reports whether the request may succeed if retried
+func (e *serverError) temporary() bool {
+	return e.status >= 500 || e.status == http.StatusTooManyRequests || e.status == http.StatusRequestTimeout
+}
+
+// serverOptions holds the flags selecting the server and the credentials of requests
+type serverOptions struct {
+	server string
+	user   string
+}
+
+// register registers the server flags on a flag set
+func (o *serverOptions) register(fs *flag.FlagSet) {
+	cfg := config.Load()
+	fs.StringVar(&o.server, "server", getEnv("IMGCAST_SERVER", "http://localhost"+cfg.Port+cfg.BasePath), "URL of the imgcast server (default: $IMGCAST_SERVER)")
+	fs.StringVar(&o.user, "user", os.Getenv("IMGCAST_USER"), "server credentials as `user:password` (default: $IMGCAST_USER)")
+}
+
+// credentials returns the username and the password of the -user flag
+func (o serverOptions) credentials() (string, string, error) {
+	username, password, ok := strings.Cut(o.user, ":")
+	if !ok || username == "" {
+		return "", "", errMissingCredentials
+	}
+	return username, password, nil
+}
+
+// endpoint returns the URL of a server path
+func (o serverOptions) endpoint(elem ...string) (string, error) {
+	u, err := url.Parse(o.server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid server URL: %q", o.server)
+	}
+	return u.JoinPath(elem...).String(), nil
+}
+
+// call sends a request without body to a server path, and returns an error unless the server accepts it
+func (o serverOptions) call(ctx context.Context, method string, elem ...string) error {
+	username, password, err := o.credentials()
+	if err != nil {
+		return err
+	}
+	endpoint, err := o.endpoint(elem...)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &serverError{status: resp.StatusCode, message: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
+
+// getEnv returns the value of an environment variable, or a default value if unset
+func getEnv(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+// runNamespace dispatches the namespace subcommands
+func runNamespace(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: imgcast namespace create|delete [flags] <name>")
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return runNamespaceCreate(args[1:])
+	case "delete":
+		return runNamespaceDelete(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown namespace command: %s\n", args[0])
+		return ExitUsage
+	}
+}
+
+// runNamespaceCreate creates a namespace in the local rooms directory
+func runNamespaceCreate(args []string) int {
+	var admins []room.Member
+
+	fs := flag.NewFlagSet("namespace create", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast namespace create [flags] <name>")
+		fs.PrintDefaults()
+	}
+	fs.Func("admin", "namespace admin as `user:password` (repeatable)", func(value string) error {
+		username, password, ok := strings.Cut(value, ":")
+		if !ok || username == "" {
+			return fmt.Errorf("expected user:password")
+		}
+		admins = append(admins, room.Member{Username: username, Password: password})
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := manager.ProvisionNamespace(fs.Arg(0), admins); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Namespace %s created\n", fs.Arg(0))
+	return ExitOK
+}
+
+// runNamespaceDelete deletes an empty namespace from the local rooms directory
+func runNamespaceDelete(args []string) int {
+	fs := flag.NewFlagSet("namespace delete", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast namespace delete <name>")
+	}
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := manager.DeleteNamespace(fs.Arg(0)); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Namespace %s deleted\n", fs.Arg(0))
+	return ExitOK
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runPush uploads an image file to a room of a local or remote server
+func runPush(args []string) int {
+	var opts uploadOptions
+
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast push [flags] <room> <file>")
+		fmt.Fprintln(fs.Output(), "\nThe image is read from the standard input if the file is \"-\".")
+		fs.PrintDefaults()
+	}
+	opts.register(fs, 3)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return ExitUsage
+	}
+	roomName, file := fs.Arg(0), fs.Arg(1)
+
+	u, err := newUploader(roomName, opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	var data []byte
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	published, err := u.upload(ctx, file, data)
+	if err != nil {
+		return fail(err)
+	}
+
+	if published {
+		fmt.Printf("Image published to %s\n", roomName)
+	} else {
+		fmt.Printf("Image unchanged in %s\n", roomName)
+	}
+	return ExitOK
+}
@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+// runRoom dispatches the room subcommands
+func runRoom(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: imgcast room create|list|delete|gc [flags] [name]")
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return runRoomCreate(args[1:])
+	case "list":
+		return runRoomList(args[1:])
+	case "delete":
+		return runRoomDelete(args[1:])
+	case "gc":
+		return runRoomGC(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown room command: %s\n", args[0])
+		return ExitUsage
+	}
+}
+
+// runRoomCreate creates a room in the local rooms directory
+func runRoomCreate(args []string) int {
+	var opts room.Options
+
+	fs := flag.NewFlagSet("room create", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast room create [flags] <name>")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.Settings.Title, "title", "", "room title")
+	fs.StringVar(&opts.Settings.Description, "description", "", "room description")
+	fs.StringVar(&opts.Settings.Background, "background", "", "viewer background color")
+	fs.StringVar(&opts.Settings.Fit, "fit", "", "viewer image fit mode (scale-down, contain, cover, fill, none)")
+	fs.BoolVar(&opts.Settings.Private, "private", false, "require member authentication to view the room")
+	fs.DurationVar(&opts.TTL, "ttl", 0, "room lifetime before garbage collection (e.g. 72h)")
+	fs.Func("member", "room member as `user:password` (repeatable)", func(value string) error {
+		username, password, ok := strings.Cut(value, ":")
+		if !ok || username == "" {
+			return fmt.Errorf("expected user:password")
+		}
+		opts.Members = append(opts.Members, room.Member{Username: username, Password: password})
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	rm, err := manager.ProvisionRoom(fs.Arg(0), opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Room %s created\n", rm.Name)
+	return ExitOK
+}
+
+// runRoomList lists the rooms of the local rooms directory, one name per line
+func runRoomList(args []string) int {
+	fs := flag.NewFlagSet("room list", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast room list [flags]")
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print the rooms with their settings and last update as JSON")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	summaries, err := manager.ListRooms()
+	if err != nil {
+		return fail(err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summaries); err != nil {
+			return fail(err)
+		}
+		return ExitOK
+	}
+	for _, summary := range summaries {
+		fmt.Println(summary.Name)
+	}
+	return ExitOK
+}
+
+// runRoomDelete deletes a room through the server, so that its viewers and timers are released
+func runRoomDelete(args []string) int {
+	var opts serverOptions
+
+	fs := flag.NewFlagSet("room delete", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast room delete [flags] <name>")
+		fs.PrintDefaults()
+	}
+	opts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	roomName := validator.NormalizeRoomPath(fs.Arg(0))
+	if err := validator.ValidateRoomPath(roomName); err != nil {
+		return fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := opts.call(ctx, http.MethodDelete, "api", "rooms", roomName); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("Room %s deleted\n", fs.Arg(0))
+	return ExitOK
+}
+
+// runRoomGC garbage collects expired and abandoned rooms from the local rooms directory
+func runRoomGC(args []string) int {
+	fs := flag.NewFlagSet("room gc", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast room gc [flags]")
+		fs.PrintDefaults()
+	}
+	maxIdleDays := fs.Int("max-idle-days", 0, "delete rooms not updated for this number of days (0 only deletes expired rooms)")
+	dryRun := fs.Bool("dry-run", false, "report the rooms to delete without deleting them")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 0 || *maxIdleDays < 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	expired, err := manager.Sweep(time.Duration(*maxIdleDays)*24*time.Hour, *dryRun)
+	if err != nil {
+		return fail(err)
+	}
+
+	action := "deleted"
+	if *dryRun {
+		action = "would be deleted"
+	}
+	for _, expiration := range expired {
+		fmt.Printf("%s %s: %s (last update: %s)\n", expiration.Room, action, expiration.Reason, expiration.LastUpdate.Format(time.RFC3339))
+	}
+	fmt.Printf("%d room(s) %s\n", len(expired), action)
+	return ExitOK
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runToken dispatches the token subcommands
+func runToken(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: imgcast token create [flags] <room>")
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return runTokenCreate(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown token command: %s\n", args[0])
+		return ExitUsage
+	}
+}
+
+// runTokenCreate creates an ingestion token for a room of the local rooms directory
+// Only the token value is printed, so that scripts can capture it.
+func runTokenCreate(args []string) int {
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast token create [flags] <room>")
+		fs.PrintDefaults()
+	}
+	label := fs.String("label", "", "token label")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	_, value, err := manager.CreateToken(fs.Arg(0), *label)
+	if err != nil {
+		return fail(err)
+	}
+
+	fmt.Println(value)
+	return ExitOK
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+const (
+	// uploadTimeout is the maximum duration of an upload attempt
+	uploadTimeout = time.Minute
+	// maxRetryDelay is the maximum delay between two upload attempts
+	maxRetryDelay = time.Minute
+)
+
+// uploadOptions holds the flags selecting the server, the credentials and the retries of uploads
+type uploadOptions struct {
+	serverOptions
+	slot    string
+	retries int
+}
+
+// register registers the upload flags on a flag set
+func (o *uploadOptions) register(fs *flag.FlagSet, retries int) {
+	o.serverOptions.register(fs)
+	fs.StringVar(&o.slot, "slot", "", "named slot to publish to (default: main room image)")
+	fs.IntVar(&o.retries, "retries", retries, "number of retries of a failed upload")
+}
+
+// uploader sends images to a room of an imgcast server, as the raw body of PUT requests
+type uploader struct {
+	client   *http.Client
+	url      string
+	username string
+	password string
+	retries  int
+}
+
+// newUploader creates an uploader publishing to a room
+func newUploader(roomName string, opts uploadOptions) (*uploader, error) {
+	roomName = validator.NormalizeRoomPath(roomName)
+	if err := validator.ValidateRoomPath(roomName); err != nil {
+		return nil, err
+	}
+	action := "live"
+	if opts.slot != "" {
+		if err := validator.ValidateSlotName(opts.slot); err != nil {
+			return nil, err
+		}
+		action = "slots/" + opts.slot + "/live"
+	}
+	username, password, err := opts.credentials()
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := opts.endpoint(roomName, action)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploader{
+		client:   &http.Client{Timeout: uploadTimeout},
+		url:      endpoint,
+		username: username,
+		password: password,
+		retries:  max(opts.retries, 0),
+	}, nil
+}
+
+// upload publishes an image, retrying failed uploads, and reports whether it was published rather than
+// skipped as a duplicate of the current image
+// The name identifies the image in the logs.
+func (u *uploader) upload(ctx context.Context, name string, data []byte) (bool, error) {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		published, err := u.put(ctx, data)
+		if err == nil {
+			return published, nil
+		}
+		var srvErr *serverError
+		if ctx.Err() != nil || (errors.As(err, &srvErr) && !srvErr.temporary()) || attempt >= u.retries {
+			return false, err
+		}
+
+		slog.Warn("Upload failed, retrying", "file", name, "delay", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// put sends an image to the server
+func (u *uploader) put(ctx context.Context, data []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.url, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(u.username, u.password)
+	contentType := imaging.ContentType(imaging.DetectFormat(data))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, &serverError{status: resp.StatusCode, message: strings.TrimSpace(string(body))}
+	}
+	// Duplicates of the current image are answered "unchanged"
+	return strings.TrimSpace(string(body)) != "unchanged", nil
+}
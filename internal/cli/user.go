@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runUser dispatches the user subcommands
+func runUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: imgcast user add|remove [flags] <room> <username>")
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "add":
+		return runUserAdd(args[1:])
+	case "remove":
+		return runUserRemove(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown user command: %s\n", args[0])
+		return ExitUsage
+	}
+}
+
+// runUserAdd adds a member to a room of the local rooms directory, or updates the password of a member
+func runUserAdd(args []string) int {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast user add [flags] <room> <username>")
+		fmt.Fprintln(fs.Output(), "\nThe password is read from the standard input unless given with -password.")
+		fs.PrintDefaults()
+	}
+	password := fs.String("password", "", "member password")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return ExitUsage
+	}
+	roomName, username := fs.Arg(0), fs.Arg(1)
+
+	if *password == "" {
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if *password == "" {
+		fmt.Fprintln(os.Stderr, "error: password required")
+		return ExitUsage
+	}
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := manager.SetMember(roomName, username, *password); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("User %s added to room %s\n", username, roomName)
+	return ExitOK
+}
+
+// runUserRemove removes a member from a room of the local rooms directory
+func runUserRemove(args []string) int {
+	fs := flag.NewFlagSet("user remove", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast user remove <room> <username>")
+	}
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return ExitUsage
+	}
+	roomName, username := fs.Arg(0), fs.Arg(1)
+
+	manager, err := newLocalManager()
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := manager.RemoveMember(roomName, username); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("User %s removed from room %s\n", username, roomName)
+	return ExitOK
+}
@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+// uploadQueueSize is the number of images waiting for upload
+const uploadQueueSize = 64
+
+// temporaryFileSuffixes are the suffixes of the files being written by common tools, which are not uploaded
+var temporaryFileSuffixes = []string{"~", ".tmp", ".part", ".partial", ".crdownload", ".swp"}
+
+// folderWatcher uploads the images written to a directory to a room
+type folderWatcher struct {
+	uploader *uploader
+	// uploaded holds the hash of the last content uploaded for each file
+	uploaded map[string][32]byte
+}
+
+// pendingFile is a file waiting for the debounce delay before its upload
+// Each write arms a new timer with a new sequence number, so that a timer fired before a later write is ignored.
+type pendingFile struct {
+	timer *time.Timer
+	seq   uint64
+}
+
+// readyFile is a file whose debounce delay elapsed, with the sequence number of its timer
+type readyFile struct {
+	path string
+	seq  uint64
+}
+
+// runWatch watches a directory and uploads the images written to it to a room of a local or remote server
+func runWatch(args []string) int {
+	var opts uploadOptions
+
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgcast watch [flags] -room <name> -dir <directory>")
+		fs.PrintDefaults()
+	}
+	roomName := fs.String("room", "", "room to publish the images to")
+	dir := fs.String("dir", "", "directory to watch")
+	debounce := fs.Duration("debounce", 500*time.Millisecond, "delay without writes before a file is uploaded")
+	opts.register(fs, 5)
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() != 0 || *roomName == "" || *dir == "" || *debounce <= 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	u, err := newUploader(*roomName, opts)
+	if err != nil {
+		return fail(err)
+	}
+
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fail(err)
+	}
+	defer notifier.Close()
+	if err := notifier.Add(*dir); err != nil {
+		return fail(fmt.Errorf("unable to watch %s: %w", *dir, err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := &folderWatcher{uploader: u, uploaded: make(map[string][32]byte)}
+	queue := make(chan string, uploadQueueSize)
+	go func() {
+		for path := range queue {
+			w.upload(ctx, path)
+		}
+	}()
+	defer close(queue)
+
+	slog.Info("Watching directory", "dir", *dir, "room", *roomName, "slot", opts.slot, "server", opts.server)
+
+	// Files are uploaded once no write happened for the debounce delay, so that partial writes are not published
+	pending := make(map[string]pendingFile)
+	ready := make(chan readyFile)
+	var seq uint64
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Watch stopped")
+			return ExitOK
+		case event, ok := <-notifier.Events:
+			if !ok {
+				return ExitError
+			}
+			if ignoredFile(event.Name) {
+				continue
+			}
+			switch {
+			case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+				if file, exists := pending[event.Name]; exists {
+					file.timer.Stop()
+				}
+				seq++
+				file := readyFile{path: event.Name, seq: seq}
+				pending[file.path] = pendingFile{seq: file.seq, timer: time.AfterFunc(*debounce, func() {
+					select {
+					case ready <- file:
+					case <-ctx.Done():
+					}
+				})}
+			case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+				if file, exists := pending[event.Name]; exists {
+					file.timer.Stop()
+					delete(pending, event.Name)
+				}
+			}
+		case file := <-ready:
+			// A timer fired before a later write of the file is stale: the timer of that write uploads it
+			if current, exists := pending[file.path]; !exists || current.seq != file.seq {
+				continue
+			}
+			delete(pending, file.path)
+			select {
+			case queue <- file.path:
+			default:
+				slog.Warn("Upload queue full, file skipped", "file", file.path)
+			}
+		case err, ok := <-notifier.Errors:
+			if !ok {
+				return ExitError
+			}
+			slog.Error("Unable to watch directory", "dir", *dir, "error", err)
+		}
+	}
+}
+
+// upload uploads an image file unless it was already uploaded with the same content
+func (w *folderWatcher) upload(ctx context.Context, path string) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Error("Unable to read file", "file", path, "error", err)
+		return
+	}
+	if imaging.DetectFormat(data) == "" {
+		slog.Debug("File skipped: not an image", "file", path)
+		return
+	}
+	hash := sha256.Sum256(data)
+	if previous, ok := w.uploaded[path]; ok && previous == hash {
+		slog.Debug("File skipped: unchanged", "file", path)
+		return
+	}
+
+	published, err := w.uploader.upload(ctx, path, data)
+	switch {
+	case ctx.Err() != nil:
+	case err != nil:
+		slog.Error("Unable to upload image", "file", path, "error", err)
+	case published:
+		w.uploaded[path] = hash
+		slog.Info("Image uploaded", "file", path)
+	default:
+		w.uploaded[path] = hash
+		slog.Info("Image unchanged", "file", path)
+	}
+}
+
+// ignoredFile reports whether a file is hidden or being written by a tool using temporary files
+func ignoredFile(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, suffix := range temporaryFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
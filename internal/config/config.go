@@ -5,23 +5,64 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
-	Port          string
-	BasePath      string
-	RoomsBaseDir  string
-	AdminHtpasswd string
+	Port            string
+	BasePath        string
+	RoomsBaseDir    string
+	AdminHtpasswd   string
+	AutoCreateRooms bool
+	RoomMaxIdle     time.Duration
+	RoomGCInterval  time.Duration
+	RoomGCDryRun    bool
+	ThumbnailSizes  []int
+	RoomIndex       bool
+	MaxUploadSize   int64
+	FetchTimeout    time.Duration
+	FetchPrivate    bool
+	WebhookNetworks string
+	PublishHooks    string
+	SMTPAddr        string
+	SMTPDomain      string
+	MQTTBroker      string
+	MQTTClientID    string
+	MQTTUsername    string
+	MQTTPassword    string
+	MQTTTopics      string
+	MQTTEventsTopic string
+	MQTTQoS         int
 }
 
 // Load reads configuration from environment variables
 func Load() *Config {
 	return &Config{
-		Port:          getPort(),
-		BasePath:      getBasePath(),
-		RoomsBaseDir:  getRoomsBaseDir(),
-		AdminHtpasswd: getAdminHtpasswd(),
+		Port:            getPort(),
+		BasePath:        getBasePath(),
+		RoomsBaseDir:    getRoomsBaseDir(),
+		AdminHtpasswd:   getAdminHtpasswd(),
+		AutoCreateRooms: getBool("ROOM_AUTO_CREATE", true),
+		RoomMaxIdle:     time.Duration(getInt("ROOM_MAX_IDLE_DAYS", 0)) * 24 * time.Hour,
+		RoomGCInterval:  getDuration("ROOM_GC_INTERVAL", time.Hour),
+		RoomGCDryRun:    getBool("ROOM_GC_DRY_RUN", false),
+		ThumbnailSizes:  getIntList("THUMBNAIL_SIZES", []int{160, 480}),
+		RoomIndex:       getBool("ROOM_INDEX", false),
+		MaxUploadSize:   int64(getInt("MAX_UPLOAD_SIZE_MB", 32)) << 20,
+		FetchTimeout:    getDuration("FETCH_TIMEOUT", 10*time.Second),
+		FetchPrivate:    getBool("FETCH_ALLOW_PRIVATE", false),
+		WebhookNetworks: os.Getenv("WEBHOOK_ALLOWED_NETWORKS"),
+		PublishHooks:    os.Getenv("PUBLISH_HOOKS_FILE"),
+		SMTPAddr:        os.Getenv("SMTP_ADDR"),
+		SMTPDomain:      getString("SMTP_DOMAIN", "imgcast.local"),
+		MQTTBroker:      os.Getenv("MQTT_BROKER"),
+		MQTTClientID:    getString("MQTT_CLIENT_ID", "imgcast"),
+		MQTTUsername:    os.Getenv("MQTT_USERNAME"),
+		MQTTPassword:    os.Getenv("MQTT_PASSWORD"),
+		MQTTTopics:      os.Getenv("MQTT_TOPICS"),
+		MQTTEventsTopic: os.Getenv("MQTT_EVENTS_TOPIC"),
+		MQTTQoS:         min(getInt("MQTT_QOS", 1), 2),
 	}
 }
 
@@ -70,6 +111,74 @@ func getAdminHtpasswd() string {
 	return dir + "/.htpasswd"
 }
 
+// getString returns a string from environment variable or the default value
+func getString(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getBool returns a boolean from environment variable or the default value
+func getBool(name string, defaultValue bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean value, using default", "name", name, "value", value)
+		return defaultValue
+	}
+	return b
+}
+
+// getInt returns a positive integer from environment variable or the default value
+func getInt(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		slog.Warn("Invalid integer value, using default", "name", name, "value", value)
+		return defaultValue
+	}
+	return i
+}
+
+// getIntList returns a comma-separated list of positive integers from environment variable or the default value
+func getIntList(name string, defaultValue []int) []int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	var list []int
+	for _, item := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || i <= 0 {
+			slog.Warn("Invalid integer list value, using default", "name", name, "value", value)
+			return defaultValue
+		}
+		list = append(list, i)
+	}
+	return list
+}
+
+// getDuration returns a duration from environment variable or the default value
+func getDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		slog.Warn("Invalid duration value, using default", "name", name, "value", value)
+		return defaultValue
+	}
+	return d
+}
+
 // JoinPath joins base path with a relative path
 func (c *Config) JoinPath(relativePath string) string {
 	if c.BasePath == "/" {
@@ -0,0 +1,229 @@
+// Package fetch sends the outgoing HTTP requests of rooms (image fetches, webhooks), guarding against server-side
+// request forgery
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is the maximum number of redirects followed when fetching a URL
+const maxRedirects = 5
+
+var (
+	// ErrInvalidURL is returned when a URL is malformed or does not use HTTP
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrForbiddenAddress is returned when a URL targets a non-public address
+	ErrForbiddenAddress = errors.New("address not allowed")
+	// ErrTooLarge is returned when the fetched content exceeds the size limit
+	ErrTooLarge = errors.New("content too large")
+	// ErrFetchFailed is the error wrapped by network, protocol and response errors
+	ErrFetchFailed = errors.New("fetch failed")
+)
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), not covered by netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Options configures a fetcher
+type Options struct {
+	// Timeout bounds the whole request, body included
+	Timeout time.Duration
+	// MaxSize is the maximum size of the fetched content, in bytes (zero means no limit)
+	MaxSize int64
+	// AllowPrivate allows fetching from loopback, private and link-local addresses
+	AllowPrivate bool
+	// AllowedNetworks are the non-public networks allowed without AllowPrivate
+	AllowedNetworks []netip.Prefix
+}
+
+// Fetcher retrieves images from HTTP URLs
+type Fetcher struct {
+	client  *http.Client
+	maxSize int64
+}
+
+// New creates a fetcher
+// Addresses are checked once resolved, when connecting, so that redirects and DNS rebinding cannot reach
+// forbidden addresses.
+func New(opts Options) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if opts.AllowPrivate {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !isAllowed(addrPort.Addr(), opts.AllowedNetworks) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+			}
+			return nil
+		},
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: opts.Timeout,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return checkURL(req.URL)
+			},
+		},
+		maxSize: opts.MaxSize,
+	}
+}
+
+// Fetch retrieves an image, returning its content to be read and closed by the caller
+// Reading beyond the size limit fails with ErrTooLarge.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	req.Header.Set("Accept", "image/*")
+	req.Header.Set("User-Agent", "imgcast")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrForbiddenAddress) {
+			return nil, fmt.Errorf("%w: %s", ErrForbiddenAddress, u.Host)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: unexpected status %s", ErrFetchFailed, resp.Status)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil &&
+		!strings.HasPrefix(mediaType, "image/") && mediaType != "application/octet-stream" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: unexpected content type %s", ErrFetchFailed, mediaType)
+	}
+	if f.maxSize > 0 && resp.ContentLength > f.maxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: at most %d bytes", ErrTooLarge, f.maxSize)
+	}
+
+	return &body{ReadCloser: resp.Body, remaining: f.maxSize, limited: f.maxSize > 0}, nil
+}
+
+// Do sends a request with the address restrictions of the fetcher, returning the response as is
+func (f *Fetcher) Do(req *http.Request) (*http.Response, error) {
+	if err := checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrForbiddenAddress) {
+			return nil, fmt.Errorf("%w: %s", ErrForbiddenAddress, req.URL.Host)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	return resp, nil
+}
+
+// body is a fetched content enforcing the size limit and wrapping read errors
+type body struct {
+	io.ReadCloser
+	remaining int64
+	limited   bool
+}
+
+// Read reads the fetched content, failing once the size limit is exceeded
+func (b *body) Read(p []byte) (int, error) {
+	if b.limited && int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.limited {
+		b.remaining -= int64(n)
+		if b.remaining < 0 {
+			return 0, ErrTooLarge
+		}
+	}
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	return n, err
+}
+
+// checkURL checks that a URL uses HTTP and names a host
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrInvalidURL, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidURL)
+	}
+	return nil
+}
+
+// isAllowed reports whether an address is public or belongs to an allowed network
+func isAllowed(addr netip.Addr, networks []netip.Prefix) bool {
+	if isPublic(addr) {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks parses a comma-separated list of networks in CIDR notation ("10.0.0.0/8,fd00::/8"),
+// single addresses standing for themselves
+func ParseNetworks(value string) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(item); err == nil {
+			networks = append(networks, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		network, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", item, err)
+		}
+		network = network.Masked()
+		if network.Addr().Is4In6() {
+			network = netip.PrefixFrom(network.Addr().Unmap(), network.Bits()-96)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isPublic reports whether an address is a public unicast address
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,182 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStandIn starts a local HTTP server answering with a content type and a body
+func newStandIn(t *testing.T, contentType, content string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetch(t *testing.T) {
+	srv := newStandIn(t, "image/png", "image data")
+	f := New(Options{Timeout: 5 * time.Second, MaxSize: 1024, AllowPrivate: true})
+
+	body, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil || string(data) != "image data" {
+		t.Errorf("got %q, %v; want %q", data, err, "image data")
+	}
+}
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	srv := newStandIn(t, "image/png", "image data")
+	f := New(Options{Timeout: 5 * time.Second})
+
+	if _, err := f.Fetch(context.Background(), srv.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Fetch of %s: got %v, want ErrForbiddenAddress", srv.URL, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("{}"))
+	if _, err := f.Do(req); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Do of %s: got %v, want ErrForbiddenAddress", srv.URL, err)
+	}
+}
+
+func TestFetchRefusesRedirectsToOtherSchemes(t *testing.T) {
+	redirect := httptest.NewServer(http.RedirectHandler("file:///etc/passwd", http.StatusFound))
+	t.Cleanup(redirect.Close)
+
+	f := New(Options{Timeout: 5 * time.Second, AllowPrivate: true})
+	if _, err := f.Fetch(context.Background(), redirect.URL); !errors.Is(err, ErrInvalidURL) {
+		t.Errorf("got %v, want ErrInvalidURL", err)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	f := New(Options{Timeout: 5 * time.Second, MaxSize: 8, AllowPrivate: true})
+
+	tests := []struct {
+		name string
+		url  func(t *testing.T) string
+		want error
+	}{
+		{name: "scheme", url: func(t *testing.T) string { return "file:///etc/passwd" }, want: ErrInvalidURL},
+		{name: "host", url: func(t *testing.T) string { return "http:///image.png" }, want: ErrInvalidURL},
+		{name: "content type", url: func(t *testing.T) string { return newStandIn(t, "text/html", "<html>").URL }, want: ErrFetchFailed},
+		{name: "size", url: func(t *testing.T) string { return newStandIn(t, "image/png", "0123456789").URL }, want: ErrTooLarge},
+		{name: "status", url: func(t *testing.T) string {
+			srv := httptest.NewServer(http.NotFoundHandler())
+			t.Cleanup(srv.Close)
+			return srv.URL
+		}, want: ErrFetchFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := f.Fetch(context.Background(), tt.url(t))
+			if err == nil {
+				_, err = io.ReadAll(body)
+				body.Close()
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchStreamedSizeLimit(t *testing.T) {
+	// Without Content-Length, the limit is enforced while reading
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		for range 4 {
+			io.WriteString(w, "0123")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	f := New(Options{Timeout: 5 * time.Second, MaxSize: 8, AllowPrivate: true})
+
+	body, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	defer body.Close()
+	if _, err := io.ReadAll(body); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":              true,
+		"2001:4860:4860::8888": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fc00::1":              false,
+		"fe80::1":              false,
+		"::ffff:10.1.2.3":      false,
+		"::ffff:8.8.8.8":       true,
+	}
+	for address, want := range tests {
+		if got := isPublic(netip.MustParseAddr(address)); got != want {
+			t.Errorf("isPublic(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestFetchAllowedNetworks(t *testing.T) {
+	srv := newStandIn(t, "image/png", "image data")
+
+	networks, err := ParseNetworks("10.0.0.0/8, 127.0.0.1")
+	if err != nil {
+		t.Fatalf("ParseNetworks: %v", err)
+	}
+	f := New(Options{Timeout: 5 * time.Second, AllowedNetworks: networks})
+	body, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch from allowed network: %v", err)
+	}
+	body.Close()
+
+	networks, _ = ParseNetworks("10.0.0.0/8")
+	f = New(Options{Timeout: 5 * time.Second, AllowedNetworks: networks})
+	if _, err := f.Fetch(context.Background(), srv.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Fetch outside allowed networks: got %v, want ErrForbiddenAddress", err)
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks("10.0.0.0/8, 192.168.1.7,fd00::/8,,::ffff:172.16.0.0/108")
+	if err != nil {
+		t.Fatalf("ParseNetworks: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.7/32", "fd00::/8", "172.16.0.0/12"}
+	if len(networks) != len(want) {
+		t.Fatalf("got %v, want %v", networks, want)
+	}
+	for i, network := range networks {
+		if network.String() != want[i] {
+			t.Errorf("network %d: got %s, want %s", i, network, want[i])
+		}
+	}
+
+	if _, err := ParseNetworks("10.0.0.0/33"); err == nil {
+		t.Error("expected an error for an invalid network")
+	}
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/auth"
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+// createRoomRequest is the payload of the room creation endpoint
+type createRoomRequest struct {
+	room.Settings
+	Name    string `json:"name"`
+	TTL     string `json:"ttl"`
+	Members []struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"members"`
+	Webhooks []room.Webhook `json:"webhooks"`
+}
+
+// createNamespaceRequest is the payload of the namespace creation endpoint
+type createNamespaceRequest struct {
+	Name   string `json:"name"`
+	Admins []struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"admins"`
+}
+
+// roomResponse is the representation of a room returned by the API
+type roomResponse struct {
+	Name     string        `json:"name"`
+	Settings room.Settings `json:"settings"`
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to encode JSON response", "error", err)
+	}
+}
+
+// writeError writes a JSON error response with the given status code
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeRoomError writes a JSON error response matching a room manager error
+func writeRoomError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, room.ErrUnauthorized):
+		w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+	case errors.Is(err, room.ErrRoomNotFound):
+		writeError(w, http.StatusNotFound, "Room not found")
+	case errors.Is(err, room.ErrRoomExists):
+		writeError(w, http.StatusConflict, "Room or namespace already exists")
+	case errors.Is(err, room.ErrNamespaceNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, room.ErrNamespaceNotEmpty):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, validator.ErrInvalidRoomName), errors.Is(err, room.ErrInvalidSettings), errors.Is(err, auth.ErrInvalidUsername),
+		errors.Is(err, room.ErrInvalidWebhook), errors.Is(err, room.ErrInvalidToken):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, room.ErrWebhooksFull), errors.Is(err, room.ErrTokensFull):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// HandleCreateRoom creates a room explicitly (requires admin Basic Auth)
+func (s *Server) HandleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Admin"`)
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req createRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	opts := room.Options{Settings: req.Settings, Webhooks: req.Webhooks}
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid TTL: expected a positive duration such as 72h")
+			return
+		}
+		opts.TTL = ttl
+	}
+	for _, member := range req.Members {
+		opts.Members = append(opts.Members, room.Member{Username: member.Username, Password: member.Password})
+	}
+
+	rm, err := s.roomManager.CreateRoom(req.Name, username, password, opts)
+	if err != nil {
+		slog.Error("Failed to create room", "room", req.Name, "error", err)
+		writeRoomError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, roomResponse{Name: rm.Name, Settings: rm.Settings()})
+}
+
+// HandleDeleteRoom deletes a room (requires admin or parent namespace admin Basic Auth)
+func (s *Server) HandleDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	roomName := validator.NormalizeRoomPath(r.PathValue("room"))
+	if !s.authorizeNamespaceAdmin(w, r, room.NamespaceOf(roomName)) {
+		return
+	}
+
+	if err := s.roomManager.DeleteRoom(roomName); err != nil {
+		slog.Error("Failed to delete room", "room", roomName, "error", err)
+		writeRoomError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetRoomSettings returns the settings of a room (requires member Basic Auth for private rooms)
+// The source configuration is only returned to room members and admins.
+func (s *Server) HandleGetRoomSettings(w http.ResponseWriter, r *http.Request) {
+	rm, err := s.roomManager.GetRoom(r.PathValue("room"))
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+
+	if rm.Settings().Private && !s.authorizeRoomMember(w, r, rm) {
+		return
+	}
+
+	settings := rm.Settings()
+	if !s.isRoomMember(r, rm) {
+		settings = settings.Public()
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// HandleUpdateRoomSettings updates the settings of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleUpdateRoomSettings(w http.ResponseWriter, r *http.Request) {
+	rm, err := s.roomManager.GetRoom(r.PathValue("room"))
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+
+	if !s.authorizeRoomMember(w, r, rm) {
+		return
+	}
+
+	// Fields missing from the payload keep their current value
+	settings := rm.Settings()
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	settings, err = s.roomManager.UpdateSettings(rm.Name, settings)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+
+	// Viewers reload the page to apply the new settings
+	rm.GetBroadcaster().Send("settings")
+
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// HandleGetMetadataReport returns the metadata removed from the current image of a room or of one of its
+// named slots (requires room member or admin Basic Auth)
+func (s *Server) HandleGetMetadataReport(w http.ResponseWriter, r *http.Request) {
+	slot := r.PathValue("slot")
+	if slot != "" {
+		if err := validator.ValidateSlotName(slot); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := s.roomManager.MetadataReport(rm.Name, slot)
+	if errors.Is(err, room.ErrNoMetadataReport) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// HandleGetSourceStatus returns the status of the pulls of the source of a room (requires room member or admin
+// Basic Auth)
+func (s *Server) HandleGetSourceStatus(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := s.roomManager.SourceStatus(rm.Name)
+	if errors.Is(err, room.ErrNoSource) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// authorizeRoomMember checks that the request carries the Basic Auth credentials of a room member or an admin
+func (s *Server) authorizeRoomMember(w http.ResponseWriter, r *http.Request, rm *room.Room) bool {
+	if s.isRoomMember(r, rm) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="Room"`)
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+	return false
+}
+
+// isRoomMember reports whether the request carries the Basic Auth credentials of a room member or an admin
+func (s *Server) isRoomMember(r *http.Request, rm *room.Room) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	authenticated, err := rm.Authenticate(username, password)
+	if err != nil {
+		slog.Error("Failed to authenticate room member", "room", rm.Name, "error", err)
+	}
+	return authenticated || s.roomManager.AuthenticateAdmin(username, password) == nil
+}
+
+// authorizeNamespaceAdmin checks that the request carries the Basic Auth credentials of an admin of the namespace
+// An empty namespace requires global admin credentials
+func (s *Server) authorizeNamespaceAdmin(w http.ResponseWriter, r *http.Request, namespace string) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		writeRoomError(w, room.ErrUnauthorized)
+		return false
+	}
+	if err := s.roomManager.AuthenticateNamespaceAdmin(namespace, username, password); err != nil {
+		writeRoomError(w, err)
+		return false
+	}
+	return true
+}
+
+// HandleCreateNamespace creates a namespace (requires admin or parent namespace admin Basic Auth)
+func (s *Server) HandleCreateNamespace(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		writeRoomError(w, room.ErrUnauthorized)
+		return
+	}
+
+	var req createNamespaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	var admins []room.Member
+	for _, admin := range req.Admins {
+		admins = append(admins, room.Member{Username: admin.Username, Password: admin.Password})
+	}
+
+	if err := s.roomManager.CreateNamespace(req.Name, username, password, admins); err != nil {
+		slog.Error("Failed to create namespace", "namespace", req.Name, "error", err)
+		writeRoomError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"name": validator.NormalizeRoomPath(req.Name)})
+}
+
+// HandleDeleteNamespace deletes an empty namespace (requires admin or parent namespace admin Basic Auth)
+func (s *Server) HandleDeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	namespace := validator.NormalizeRoomPath(r.PathValue("namespace"))
+	if !s.authorizeNamespaceAdmin(w, r, room.NamespaceOf(namespace)) {
+		return
+	}
+
+	if err := s.roomManager.DeleteNamespace(namespace); err != nil {
+		slog.Error("Failed to delete namespace", "namespace", namespace, "error", err)
+		writeRoomError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,15 +2,27 @@ package handlers
 
 import (
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
 	"io/fs"
 	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ncarlier/imgcast/internal/config"
+	"github.com/ncarlier/imgcast/internal/fetch"
+	"github.com/ncarlier/imgcast/internal/hook"
+	"github.com/ncarlier/imgcast/internal/imaging"
 	"github.com/ncarlier/imgcast/internal/room"
 	"github.com/ncarlier/imgcast/internal/storage"
+	"github.com/ncarlier/imgcast/pkg/validator"
 )
 
 // Server holds the HTTP handlers and dependencies
@@ -18,7 +30,26 @@ type Server struct {
 	config       *config.Config
 	roomManager  *room.Manager
 	storage      *storage.Storage
+	fetcher      *fetch.Fetcher
 	staticServer http.Handler
+	viewerTmpl   *template.Template
+	indexTmpl    *template.Template
+}
+
+// viewerData holds the data injected into the viewer page
+type viewerData struct {
+	Name     string
+	Settings room.Settings
+	// Slots are the slots displayed by the grid layout ("" being the main room image)
+	Slots []string
+	// Columns is the number of columns of the grid layout
+	Columns int
+}
+
+// indexEntry holds the data of a room listed by the index page
+type indexEntry struct {
+	room.Summary
+	HasImage bool
 }
 
 // NewServer creates a new server instance
@@ -29,46 +60,74 @@ func NewServer(cfg *config.Config, roomManager *room.Manager, storage *storage.S
 		return nil, fmt.Errorf("failed to setup static file server: %w", err)
 	}
 
+	// Parse viewer page template
+	viewerTmpl, err := template.ParseFS(fSys, "index.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse viewer template: %w", err)
+	}
+	indexTmpl, err := template.ParseFS(fSys, "rooms.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse index template: %w", err)
+	}
+
 	return &Server{
-		config:       cfg,
-		roomManager:  roomManager,
-		storage:      storage,
+		config:      cfg,
+		roomManager: roomManager,
+		storage:     storage,
+		fetcher: fetch.New(fetch.Options{
+			Timeout:      cfg.FetchTimeout,
+			MaxSize:      cfg.MaxUploadSize,
+			AllowPrivate: cfg.FetchPrivate,
+		}),
 		staticServer: http.FileServer(http.FS(fSys)),
+		viewerTmpl:   viewerTmpl,
+		indexTmpl:    indexTmpl,
 	}, nil
 }
 
-// extractRoomName extracts the room name from a request path
-// Path format: /{roomname}/upload or /{roomname}/live or /{roomname}/events or /{roomname}
-func (s *Server) extractRoomName(path string) string {
-	// Remove base path if present
-	if s.config.BasePath != "/" {
-		path = strings.TrimPrefix(path, strings.TrimSuffix(s.config.BasePath, "/"))
+// writeLookupError writes a plain text error matching a room lookup error
+func writeLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, validator.ErrInvalidRoomName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	http.Error(w, "Room not found", http.StatusNotFound)
+}
 
-	// Remove leading slash
-	path = strings.TrimPrefix(path, "/")
+// authorizeViewer checks that the request is allowed to view a room
+// Private rooms require the Basic Auth credentials of a room member
+func (s *Server) authorizeViewer(w http.ResponseWriter, r *http.Request, rm *room.Room) bool {
+	if !rm.Settings().Private {
+		return true
+	}
 
-	// Split by slash and get first part
-	parts := strings.Split(path, "/")
-	if len(parts) > 0 && parts[0] != "" {
-		return parts[0]
+	if username, password, ok := r.BasicAuth(); ok {
+		authenticated, err := rm.Authenticate(username, password)
+		if err != nil {
+			slog.Error("Failed to authenticate viewer", "room", rm.Name, "error", err)
+		}
+		if authenticated {
+			return true
+		}
 	}
 
-	return ""
+	w.Header().Set("WWW-Authenticate", `Basic realm="Room Viewer"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
 }
 
-// HandleUpload handles image upload to a room
+// HandleUpload handles image upload to a room or to one of its named slots
+// The image is either the "image" file of a multipart form, the raw body of PUT requests and of requests
+// with an image content type, streamed into storage, or fetched from the "url" of a JSON body. With a "publish_at" query parameter,
+// or form field of multipart forms (RFC 3339 date), the image is stored as pending and published at that time.
 func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Extract room name from path
-	roomName := s.extractRoomName(r.URL.Path)
-	if roomName == "" {
-		http.Error(w, "Room name required", http.StatusBadRequest)
-		return
+	roomName := r.PathValue("room")
+	slot := r.PathValue("slot")
+	if slot != "" {
+		if err := validator.ValidateSlotName(slot); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Get basic auth credentials
@@ -80,7 +139,11 @@ func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get or create room with authentication
-	room, created, err := s.roomManager.GetOrCreateRoom(roomName, username, password)
+	rm, created, err := s.roomManager.GetOrCreateRoom(roomName, username, password)
+	if errors.Is(err, room.ErrRoomNotFound) || errors.Is(err, validator.ErrInvalidRoomName) {
+		writeLookupError(w, err)
+		return
+	}
 	if err != nil {
 		slog.Error("Failed to get/create room", "room", roomName, "error", err)
 		w.Header().Set("WWW-Authenticate", `Basic realm="Room Upload"`)
@@ -89,44 +152,168 @@ func (s *Server) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if created {
-		slog.Info("New room created via upload", "room", roomName, "creator", username)
+		slog.Info("New room created via upload", "room", rm.Name, "creator", username)
 	}
 
-	// Parse multipart form
-	file, _, err := r.FormFile("image")
+	// Read the image from the request body or from the multipart form
+	if s.config.MaxUploadSize > 0 {
+		if r.ContentLength > s.config.MaxUploadSize {
+			writeUploadError(w, &http.MaxBytesError{Limit: s.config.MaxUploadSize})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadSize)
+	}
+	file, err := s.uploadBody(r)
 	if err != nil {
-		http.Error(w, "Image not provided", http.StatusBadRequest)
+		writeUploadError(w, err)
 		return
 	}
 	defer file.Close()
 
-	// Save the image
-	if err := s.storage.SaveImage(roomName, file); err != nil {
-		slog.Error("Failed to save image", "room", roomName, "error", err)
-		http.Error(w, "Unable to save image", http.StatusInternalServerError)
+	// Schedule the publication if requested
+	// Only multipart forms are parsed: the raw body of other requests is the image.
+	value := r.URL.Query().Get("publish_at")
+	if r.MultipartForm != nil {
+		value = r.FormValue("publish_at")
+	}
+	if value != "" {
+		publishAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Invalid publish_at: expected an RFC 3339 date", http.StatusBadRequest)
+			return
+		}
+		publication, err := s.roomManager.SchedulePublication(rm, slot, publishAt, username, file)
+		var rejected *hook.RejectedError
+		if isUploadError(err) || errors.Is(err, room.ErrEmptyImage) || errors.As(err, &rejected) || errors.Is(err, hook.ErrHookFailed) {
+			writePublishError(w, rm, slot, username, err)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to schedule image", "room", rm.Name, "slot", slot, "error", err)
+			writePendingError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, publication)
 		return
 	}
 
-	slog.Info("Image uploaded", "room", roomName, "user", username)
+	// Publish the image
+	published, err := s.roomManager.Publish(rm, slot, file)
+	if err != nil {
+		writePublishError(w, rm, slot, username, err)
+		return
+	}
+	if !published {
+		slog.Info("Duplicate image skipped", "room", rm.Name, "slot", slot, "user", username)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "unchanged")
+		return
+	}
 
-	// Notify all connected clients
-	room.GetBroadcaster().Notify()
+	slog.Info("Image uploaded", "room", rm.Name, "slot", slot, "user", username)
 
 	w.WriteHeader(http.StatusOK)
 }
 
-// HandleLive serves the current image for a room
+// uploadBody returns the image of an upload request
+func (s *Server) uploadBody(r *http.Request) (io.ReadCloser, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var body struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+			return nil, http.ErrMissingFile
+		}
+		return s.fetcher.Fetch(r.Context(), body.URL)
+	}
+	if strings.HasPrefix(mediaType, "image/") || (r.Method == http.MethodPut && !strings.HasPrefix(mediaType, "multipart/")) {
+		if r.ContentLength == 0 {
+			return nil, http.ErrMissingFile
+		}
+		return r.Body, nil
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// isUploadError reports whether an error is caused by reading an invalid upload request body
+func isUploadError(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, fetch.ErrInvalidURL) || errors.Is(err, fetch.ErrForbiddenAddress) || errors.Is(err, fetch.ErrTooLarge) || errors.Is(err, fetch.ErrFetchFailed)
+}
+
+// writeUploadError writes a plain text error matching an upload request body error
+func writeUploadError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		http.Error(w, fmt.Sprintf("Image too large: at most %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		http.Error(w, "Incomplete upload", http.StatusBadRequest)
+	case errors.Is(err, fetch.ErrInvalidURL):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, fetch.ErrForbiddenAddress):
+		http.Error(w, "Image URL not allowed", http.StatusBadRequest)
+	case errors.Is(err, fetch.ErrTooLarge):
+		http.Error(w, "Image too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, fetch.ErrFetchFailed):
+		http.Error(w, "Unable to fetch image: "+err.Error(), http.StatusBadGateway)
+	default:
+		http.Error(w, "Image not provided", http.StatusBadRequest)
+	}
+}
+
+// writePublishError writes a plain text error matching an image publication error
+func writePublishError(w http.ResponseWriter, rm *room.Room, slot, username string, err error) {
+	var rejected *hook.RejectedError
+	switch {
+	case isUploadError(err):
+		writeUploadError(w, err)
+	case errors.Is(err, room.ErrInvalidFileName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, room.ErrEmptyImage):
+		http.Error(w, "Empty image", http.StatusBadRequest)
+	case errors.Is(err, imaging.ErrImageTooLarge):
+		http.Error(w, fmt.Sprintf("Image too large: at most %d pixels", imaging.MaxPixels), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, imaging.ErrUnsupportedImage):
+		http.Error(w, "Unsupported image format", http.StatusUnsupportedMediaType)
+	case errors.As(err, &rejected):
+		slog.Info("Image rejected", "room", rm.Name, "slot", slot, "user", username, "hook", rejected.Hook, "reason", rejected.Reason)
+		http.Error(w, rejected.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, hook.ErrHookFailed):
+		slog.Error("Failed to run publish hooks", "room", rm.Name, "slot", slot, "error", err)
+		http.Error(w, "Unable to validate image", http.StatusBadGateway)
+	default:
+		slog.Error("Failed to save image", "room", rm.Name, "slot", slot, "error", err)
+		http.Error(w, "Unable to save image", http.StatusInternalServerError)
+	}
+}
+
+// HandleLive serves the current image for a room or for one of its named slots
+// The "w", "h", "fit" and "format" query parameters, and the Accept header, select a resized or converted rendition.
 func (s *Server) HandleLive(w http.ResponseWriter, r *http.Request) {
-	// Extract room name from path
-	roomName := s.extractRoomName(r.URL.Path)
-	if roomName == "" {
-		http.Error(w, "Room name required", http.StatusBadRequest)
-		return
+	slot := r.PathValue("slot")
+	if slot != "" {
+		if err := validator.ValidateSlotName(slot); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Check if room exists
-	if !s.storage.RoomExists(roomName) {
-		http.Error(w, "Room not found", http.StatusNotFound)
+	rm, err := s.roomManager.GetRoom(r.PathValue("room"))
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
+
+	if !s.authorizeViewer(w, r, rm) {
 		return
 	}
 
@@ -135,24 +322,112 @@ func (s *Server) HandleLive(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Expires", "0")
 
-	// Serve the image file
-	imagePath := s.storage.GetRoomImagePath(roomName)
-	http.ServeFile(w, r, imagePath)
+	// Serve the image file, or one of its renditions
+	imagePath := s.storage.GetSlotImagePath(rm.Name, slot)
+	opts, err := imaging.ParseOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+
+	original, err := imaging.DetectFileFormat(imagePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if opts.Format == "" {
+		if !opts.Resizes() && !imaging.CanDecode(original) {
+			// Images that cannot be converted are served as is
+			opts.Format = original
+		} else {
+			opts.Format = imaging.Negotiate(r.Header.Get("Accept"), original, opts.Resizes())
+		}
+	}
+
+	if opts.Format == original && !opts.Resizes() {
+		if contentType := imaging.ContentType(original); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		http.ServeFile(w, r, imagePath)
+		return
+	}
+
+	if !imaging.CanEncode(opts.Format) {
+		http.Error(w, fmt.Sprintf("Unable to convert images to %s", opts.Format), http.StatusNotAcceptable)
+		return
+	}
+
+	renditionPath, err := s.roomManager.Rendition(rm, slot, opts)
+	if errors.Is(err, imaging.ErrUnsupportedImage) {
+		http.Error(w, "Unable to convert this image", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to render image", "room", rm.Name, "slot", slot, "error", err)
+		http.Error(w, "Unable to render image", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", imaging.ContentType(opts.Format))
+	http.ServeFile(w, r, renditionPath)
 }
 
-// HandleSSE handles Server-Sent Events for a room
-func (s *Server) HandleSSE(w http.ResponseWriter, r *http.Request) {
-	// Extract room name from path
-	roomName := s.extractRoomName(r.URL.Path)
-	if roomName == "" {
-		http.Error(w, "Room name required", http.StatusBadRequest)
+// HandleThumbnail serves the thumbnail of the current image of a room or of one of its named slots
+// The "size" query parameter selects the smallest thumbnail at least that large.
+func (s *Server) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
+	slot := r.PathValue("slot")
+	if slot != "" {
+		if err := validator.ValidateSlotName(slot); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	size := 0
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		if size, err = strconv.Atoi(value); err != nil || size <= 0 {
+			http.Error(w, "Invalid size: expected a positive number of pixels", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rm, err := s.roomManager.GetRoom(r.PathValue("room"))
+	if err != nil {
+		writeLookupError(w, err)
 		return
 	}
 
+	if !s.authorizeViewer(w, r, rm) {
+		return
+	}
+
+	thumbnailPath, err := s.roomManager.Thumbnail(rm, slot, size)
+	if errors.Is(err, room.ErrNoThumbnail) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get thumbnail", "room", rm.Name, "slot", slot, "error", err)
+		http.Error(w, "Unable to get thumbnail", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, thumbnailPath)
+}
+
+// HandleSSE handles Server-Sent Events for a room
+func (s *Server) HandleSSE(w http.ResponseWriter, r *http.Request) {
 	// Get the room (creates if exists on disk)
-	room, err := s.roomManager.GetRoom(roomName)
+	rm, err := s.roomManager.GetRoom(r.PathValue("room"))
 	if err != nil {
-		http.Error(w, "Room not found", http.StatusNotFound)
+		writeLookupError(w, err)
+		return
+	}
+
+	if !s.authorizeViewer(w, r, rm) {
 		return
 	}
 
@@ -169,59 +444,164 @@ func (s *Server) HandleSSE(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add client to broadcaster
-	client := room.GetBroadcaster().AddClient(w, flusher)
+	client := rm.GetBroadcaster().AddClient(w, flusher)
 
-	// Keep connection alive until client disconnects
-	<-r.Context().Done()
+	// Keep connection alive until client disconnects or room is deleted
+	select {
+	case <-r.Context().Done():
+	case <-client.Done():
+	}
 
 	// Remove client from broadcaster
-	room.GetBroadcaster().RemoveClient(client)
+	rm.GetBroadcaster().RemoveClient(client)
 }
 
-// HandleStatic serves static files for a room
-func (s *Server) HandleStatic(w http.ResponseWriter, r *http.Request) {
-	// Extract room name from path
-	roomName := s.extractRoomName(r.URL.Path)
+// HandleViewer serves the viewer page of a room
+func (s *Server) HandleViewer(w http.ResponseWriter, r *http.Request) {
+	// Check if room exists
+	rm, err := s.roomManager.GetRoom(r.PathValue("room"))
+	if err != nil {
+		writeLookupError(w, err)
+		return
+	}
 
-	// If request is for favicon.ico, serve it directly
-	if roomName == "favicon.ico" {
-		s.staticServer.ServeHTTP(w, r)
+	if !s.authorizeViewer(w, r, rm) {
 		return
 	}
 
-	// Check if room exists
-	if !s.storage.RoomExists(roomName) {
-		http.Error(w, "Room not found", http.StatusNotFound)
+	s.renderViewer(w, rm)
+}
+
+// HandleIndex serves the index page listing the public rooms with their thumbnail
+func (s *Server) HandleIndex(w http.ResponseWriter, r *http.Request) {
+	summaries, err := s.roomManager.ListRooms()
+	if err != nil {
+		slog.Error("Failed to list rooms", "error", err)
+		http.Error(w, "Unable to list rooms", http.StatusInternalServerError)
 		return
 	}
 
-	// Trim room name from URL path to serve static files correctly
-	r.URL.Path = strings.TrimPrefix(r.URL.Path, "/"+roomName)
+	entries := []indexEntry{}
+	for _, summary := range summaries {
+		if summary.Settings.Private {
+			continue
+		}
+		summary.Settings = summary.Settings.Public()
+		entries = append(entries, indexEntry{Summary: summary, HasImage: s.storage.HasImage(summary.Name, "")})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := s.indexTmpl.Execute(w, entries); err != nil {
+		slog.Error("Failed to render index page", "error", err)
+	}
+}
+
+// HandleFavicon serves the favicon, at the root or relative to a room viewer page
+func (s *Server) HandleFavicon(w http.ResponseWriter, r *http.Request) {
+	r.URL.Path = "/favicon.ico"
 	s.staticServer.ServeHTTP(w, r)
 }
 
-// RegisterRoutes registers all HTTP routes
-func (s *Server) RegisterRoutes() {
-	// Main handler that routes to appropriate endpoints
-	mainHandler := func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-
-		// Check if this is a room-specific endpoint
-		if strings.HasSuffix(path, "/upload") {
-			s.HandleUpload(w, r)
-		} else if strings.HasSuffix(path, "/live") {
-			s.HandleLive(w, r)
-		} else if strings.HasSuffix(path, "/events") {
-			s.HandleSSE(w, r)
-		} else {
-			s.HandleStatic(w, r)
+// renderViewer renders the viewer page with the room settings
+func (s *Server) renderViewer(w http.ResponseWriter, rm *room.Room) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	data := viewerData{Name: rm.Name, Settings: rm.Settings().Public()}
+	if data.Settings.Layout == "grid" {
+		data.Slots = data.Settings.GridSlots
+		if len(data.Slots) == 0 {
+			// Display the main image, if any, followed by all the named slots
+			if s.storage.HasImage(rm.Name, "") {
+				data.Slots = append(data.Slots, "")
+			}
+			slots, err := s.storage.ListSlots(rm.Name)
+			if err != nil {
+				slog.Error("Failed to list room slots", "room", rm.Name, "error", err)
+			}
+			data.Slots = append(data.Slots, slots...)
 		}
+		data.Columns = data.Settings.GridColumns
+		if data.Columns == 0 {
+			data.Columns = max(1, int(math.Ceil(math.Sqrt(float64(len(data.Slots))))))
+		}
+	}
+	if err := s.viewerTmpl.Execute(w, data); err != nil {
+		slog.Error("Failed to render viewer page", "room", rm.Name, "error", err)
 	}
+}
+
+// RegisterRoutes registers all HTTP routes
+func (s *Server) RegisterRoutes() {
+	mux := s.routes()
 
 	// Register main handler
 	if s.config.BasePath == "/" {
-		http.HandleFunc("/", mainHandler)
+		http.Handle("/", mux)
 	} else {
-		http.Handle(s.config.BasePath, http.StripPrefix(strings.TrimSuffix(s.config.BasePath, "/"), http.HandlerFunc(mainHandler)))
+		http.Handle(s.config.BasePath, http.StripPrefix(strings.TrimSuffix(s.config.BasePath, "/"), mux))
 	}
 }
+
+// routes returns the handler of all HTTP routes, relative to the base path
+// Room paths may contain namespaces ("/acme/marketing/lobby/live"), so room endpoints are dispatched
+// by their trailing action segment rather than by fixed patterns.
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Admin API endpoints
+	mux.HandleFunc("POST /api/rooms", s.HandleCreateRoom)
+	mux.Handle("/api/rooms/{path...}", roomRoutes{
+		"":         {http.MethodDelete: s.HandleDeleteRoom},
+		"settings": {http.MethodGet: s.HandleGetRoomSettings, http.MethodPut: s.HandleUpdateRoomSettings},
+		"playlist": {
+			http.MethodGet:  s.HandleGetPlaylist,
+			http.MethodPost: s.HandleAddPlaylistItem,
+			http.MethodPut:  s.HandleUpdatePlaylist,
+		},
+		"playlist/start":           {http.MethodPost: s.handlePlaylistControl(s.roomManager.StartPlaylist)},
+		"playlist/pause":           {http.MethodPost: s.handlePlaylistControl(s.roomManager.PausePlaylist)},
+		"playlist/skip":            {http.MethodPost: s.handlePlaylistControl(s.roomManager.SkipPlaylist)},
+		"pending":                  {http.MethodGet: s.HandleListPending},
+		"pending/{id}":             {http.MethodDelete: s.HandleCancelPending},
+		"metadata":                 {http.MethodGet: s.HandleGetMetadataReport},
+		"slots/{slot}/metadata":    {http.MethodGet: s.HandleGetMetadataReport},
+		"watermark":                {http.MethodPut: s.HandleSetWatermark, http.MethodDelete: s.HandleDeleteWatermark},
+		"original":                 {http.MethodGet: s.HandleGetOriginal},
+		"slots/{slot}/original":    {http.MethodGet: s.HandleGetOriginal},
+		"source":                   {http.MethodGet: s.HandleGetSourceStatus},
+		"webhooks":                 {http.MethodGet: s.HandleListWebhooks, http.MethodPost: s.HandleAddWebhook},
+		"webhooks/{id}":            {http.MethodDelete: s.HandleDeleteWebhook},
+		"webhooks/{id}/deliveries": {http.MethodGet: s.HandleListWebhookDeliveries},
+		"tokens":                   {http.MethodGet: s.HandleListTokens, http.MethodPost: s.HandleCreateToken},
+		"tokens/{id}":              {http.MethodDelete: s.HandleDeleteToken},
+	}.mustBeUnambiguous())
+	mux.HandleFunc("POST /api/namespaces", s.HandleCreateNamespace)
+	mux.HandleFunc("DELETE /api/namespaces/{namespace...}", s.HandleDeleteNamespace)
+
+	// Static files
+	mux.HandleFunc("GET /favicon.ico", s.HandleFavicon)
+
+	// Room index page
+	if s.config.RoomIndex {
+		mux.HandleFunc("GET /{$}", s.HandleIndex)
+	}
+
+	// Room endpoints
+	mux.Handle("/{path...}", roomRoutes{
+		"":                    {http.MethodGet: s.HandleViewer},
+		"upload":              {http.MethodPost: s.HandleUpload},
+		"live":                {http.MethodGet: s.HandleLive, http.MethodPut: s.HandleUpload},
+		"thumb":               {http.MethodGet: s.HandleThumbnail},
+		"slots/{slot}/upload": {http.MethodPost: s.HandleUpload},
+		"slots/{slot}/live":   {http.MethodGet: s.HandleLive, http.MethodPut: s.HandleUpload},
+		"slots/{slot}/thumb":  {http.MethodGet: s.HandleThumbnail},
+		"events":              {http.MethodGet: s.HandleSSE},
+		"favicon.ico":         {http.MethodGet: s.HandleFavicon},
+		"dav":                 s.davCollectionRoutes(),
+		"dav/":                s.davCollectionRoutes(),
+		"dav/{file}":          s.davFileRoutes(),
+	}.mustBeUnambiguous())
+
+	return mux
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+// maxWatermarkBody is the maximum size of the multipart form holding a watermark, headers included
+const maxWatermarkBody = room.MaxWatermarkSize + 64<<10
+
+// HandleSetWatermark sets the watermark stamped onto the images published to a room (requires room member or
+// admin Basic Auth)
+// The multipart form holds the "image" file.
+func (s *Server) HandleSetWatermark(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWatermarkBody)
+	file, _, err := r.FormFile("image")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Watermark too large: at most %d bytes", room.MaxWatermarkSize))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Image not provided")
+		return
+	}
+	defer file.Close()
+
+	if err := s.roomManager.SetWatermark(rm.Name, file); err != nil {
+		if errors.Is(err, imaging.ErrUnsupportedImage) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, room.ErrWatermarkTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		slog.Error("Failed to set watermark", "room", rm.Name, "error", err)
+		writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteWatermark removes the watermark of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleDeleteWatermark(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.roomManager.DeleteWatermark(rm.Name); err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetOriginal serves the current image of a room or of one of its named slots as uploaded, before its
+// overlays were applied (requires room member or admin Basic Auth)
+func (s *Server) HandleGetOriginal(w http.ResponseWriter, r *http.Request) {
+	slot := r.PathValue("slot")
+	if slot != "" {
+		if err := validator.ValidateSlotName(slot); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	http.ServeFile(w, r, s.roomManager.OriginalImagePath(rm, slot))
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+// writePendingError writes a JSON error response matching a scheduled publication error
+func writePendingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, room.ErrInvalidPublishTime):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, room.ErrPendingFull):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, room.ErrPublicationNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		writeRoomError(w, err)
+	}
+}
+
+// HandleListPending returns the scheduled publications of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleListPending(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	publications, err := s.roomManager.ListPublications(rm.Name)
+	if err != nil {
+		writePendingError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, publications)
+}
+
+// HandleCancelPending cancels a scheduled publication of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleCancelPending(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.roomManager.CancelPublication(rm.Name, r.PathValue("id")); err != nil {
+		writePendingError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
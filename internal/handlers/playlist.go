@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/hook"
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+// updatePlaylistRequest is the payload of the playlist update endpoint
+type updatePlaylistRequest struct {
+	Items []room.PlaylistItem `json:"items"`
+}
+
+// writePlaylistError writes a JSON error response matching a playlist error
+func writePlaylistError(w http.ResponseWriter, err error) {
+	var rejected *hook.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		writeError(w, http.StatusUnprocessableEntity, rejected.Error())
+	case errors.Is(err, hook.ErrHookFailed):
+		writeError(w, http.StatusBadGateway, "Unable to validate image")
+	case errors.Is(err, room.ErrPlaylistEmpty), errors.Is(err, room.ErrPlaylistFull):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, room.ErrPlaylistItemNotFound), errors.Is(err, room.ErrEmptyImage):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeRoomError(w, err)
+	}
+}
+
+// lookupRoomMember returns the room of the request if it carries the credentials of a room member or an admin
+func (s *Server) lookupRoomMember(w http.ResponseWriter, r *http.Request) (*room.Room, bool) {
+	rm, err := s.roomManager.GetRoom(r.PathValue("room"))
+	if err != nil {
+		writeRoomError(w, err)
+		return nil, false
+	}
+	if !s.authorizeRoomMember(w, r, rm) {
+		return nil, false
+	}
+	return rm, true
+}
+
+// HandleGetPlaylist returns the playlist of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleGetPlaylist(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	playlist, err := s.roomManager.GetPlaylist(rm.Name)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, playlist)
+}
+
+// HandleAddPlaylistItem appends an uploaded image to the playlist of a room (requires room member or admin Basic Auth)
+// The multipart form holds the "image" file, and optionally its "name" and display "duration" in seconds.
+func (s *Server) HandleAddPlaylistItem(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Image not provided")
+		return
+	}
+	defer file.Close()
+
+	var duration time.Duration
+	if value := r.FormValue("duration"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			writeError(w, http.StatusBadRequest, "Invalid duration: expected a positive number of seconds")
+			return
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+
+	item, err := s.roomManager.AddPlaylistItem(rm.Name, r.FormValue("name"), duration, file)
+	var rejected *hook.RejectedError
+	if errors.As(err, &rejected) {
+		slog.Info("Playlist item rejected", "room", rm.Name, "hook", rejected.Hook, "reason", rejected.Reason)
+	} else if err != nil {
+		slog.Error("Failed to add playlist item", "room", rm.Name, "error", err)
+	}
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// HandleUpdatePlaylist reorders, edits and removes playlist items (requires room member or admin Basic Auth)
+func (s *Server) HandleUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	var req updatePlaylistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	playlist, err := s.roomManager.UpdatePlaylist(rm.Name, req.Items)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, playlist)
+}
+
+// handlePlaylistControl returns a handler applying a playlist control (start, pause, skip)
+func (s *Server) handlePlaylistControl(control func(roomName string) (room.Playlist, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rm, ok := s.lookupRoomMember(w, r)
+		if !ok {
+			return
+		}
+
+		playlist, err := control(rm.Name)
+		if err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, playlist)
+	}
+}
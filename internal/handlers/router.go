@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+// roomRoutes maps the action ending a room path ("" for the room itself) to handlers by method
+// An action may span several segments ("playlist/start"), and capture path values with wildcard
+// segments ("slots/{slot}/live"). Actions ending with a slash ("dav/") match paths with a trailing slash.
+type roomRoutes map[string]map[string]http.HandlerFunc
+
+// ServeHTTP dispatches a request whose "path" value is a room path optionally followed by an action
+// The first segment of every action is a reserved room name (see mustBeUnambiguous), so the split between the room path and
+// the action is unambiguous. The room path is exposed to handlers as the "room" path value, and the
+// action wildcards as path values of the same name ("slot" being always set, empty for the main image).
+func (routes roomRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	roomPath, values, action := routes.split(r.PathValue("path"))
+	if roomPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	handlers, ok := routes[action]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	handler, ok := handlers[method]
+	if !ok {
+		allowed := make([]string, 0, len(handlers))
+		for m := range handlers {
+			allowed = append(allowed, m)
+		}
+		slices.Sort(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.SetPathValue("room", roomPath)
+	r.SetPathValue("slot", "")
+	for name, value := range values {
+		r.SetPathValue(name, value)
+	}
+	handler(w, r)
+}
+
+// mustBeUnambiguous panics if the first segment of an action is a valid room name, as the action could not
+// be told apart from a nested room path: it must be added to the reserved room names
+func (routes roomRoutes) mustBeUnambiguous() roomRoutes {
+	for key := range routes {
+		if key == "" {
+			continue
+		}
+		first, _, _ := strings.Cut(key, "/")
+		if validator.ValidateRoomName(first) == nil {
+			panic(fmt.Sprintf("room action %q conflicts with room names: reserve %q", key, first))
+		}
+	}
+	return routes
+}
+
+// split separates a request path into a room path, the action wildcard values and the longest matching action
+func (routes roomRoutes) split(path string) (roomPath string, values map[string]string, action string) {
+	// A trailing slash designates the room itself, unless it ends a collection action
+	collection := strings.HasSuffix(path, "/")
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	matched := 0
+	for key := range routes {
+		keySegments := strings.Split(strings.TrimSuffix(key, "/"), "/")
+		if key == "" || strings.HasSuffix(key, "/") != collection || len(keySegments) >= len(segments) || len(keySegments) <= matched {
+			continue
+		}
+
+		tail := segments[len(segments)-len(keySegments):]
+		keyValues := map[string]string{}
+		match := true
+		for i, keySegment := range keySegments {
+			switch {
+			case strings.HasPrefix(keySegment, "{") && strings.HasSuffix(keySegment, "}"):
+				keyValues[keySegment[1:len(keySegment)-1]] = tail[i]
+			case keySegment != tail[i]:
+				match = false
+			}
+		}
+		if match {
+			action, values, matched = key, keyValues, len(keySegments)
+		}
+	}
+
+	return strings.Join(segments[:len(segments)-matched], "/"), values, action
+}
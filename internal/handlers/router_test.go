@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ncarlier/imgcast/internal/config"
+)
+
+func TestRoomRoutesSplit(t *testing.T) {
+	routes := roomRoutes{
+		"":                  {},
+		"live":              {},
+		"slots/{slot}/live": {},
+		"playlist":          {},
+		"playlist/start":    {},
+		"dav":               {},
+		"dav/":              {},
+		"dav/{file}":        {},
+	}
+
+	tests := []struct {
+		path   string
+		room   string
+		action string
+		values map[string]string
+	}{
+		{path: "lobby", room: "lobby", action: ""},
+		{path: "lobby/", room: "lobby", action: ""},
+		{path: "acme/lobby", room: "acme/lobby", action: ""},
+		{path: "lobby/live", room: "lobby", action: "live"},
+		{path: "acme/marketing/lobby/live", room: "acme/marketing/lobby", action: "live"},
+		{path: "lobby/slots/cam1/live", room: "lobby", action: "slots/{slot}/live", values: map[string]string{"slot": "cam1"}},
+		{path: "lobby/playlist/start", room: "lobby", action: "playlist/start"},
+		{path: "lobby/dav", room: "lobby", action: "dav"},
+		{path: "lobby/dav/", room: "lobby", action: "dav/"},
+		{path: "lobby/dav/photo.jpg", room: "lobby", action: "dav/{file}", values: map[string]string{"file": "photo.jpg"}},
+		{path: "live", room: "live", action: ""},
+	}
+	for _, tt := range tests {
+		room, values, action := routes.split(tt.path)
+		if room != tt.room || action != tt.action {
+			t.Errorf("split(%q) = %q, %q; want %q, %q", tt.path, room, action, tt.room, tt.action)
+		}
+		if len(tt.values) > 0 && !maps.Equal(values, tt.values) {
+			t.Errorf("split(%q) values = %v; want %v", tt.path, values, tt.values)
+		}
+	}
+}
+
+func TestRoomRoutesServeHTTP(t *testing.T) {
+	var gotRoom, gotSlot string
+	routes := roomRoutes{
+		"slots/{slot}/live": {http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+			gotRoom, gotSlot = r.PathValue("room"), r.PathValue("slot")
+		}},
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/{path...}", routes)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/acme/lobby/slots/cam1/live", nil))
+	if rec.Code != http.StatusOK || gotRoom != "acme/lobby" || gotSlot != "cam1" {
+		t.Errorf("got status %d, room %q, slot %q", rec.Code, gotRoom, gotSlot)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/lobby/slots/cam1/live", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("got status %d, Allow %q; want 405, GET", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestRoomRoutesMustBeUnambiguous(t *testing.T) {
+	roomRoutes{"live": {}, "slots/{slot}/live": {}, "favicon.ico": {}, "dav/": {}}.mustBeUnambiguous()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an action starting with an unreserved room name")
+		}
+	}()
+	roomRoutes{"gallery": {}}.mustBeUnambiguous()
+}
+
+func TestRegisteredRoutesAreUnambiguous(t *testing.T) {
+	s := &Server{config: &config.Config{}}
+	// Building the routes panics if an action starts with an unreserved room name
+	s.routes()
+}
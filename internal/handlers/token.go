@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+// createTokenRequest is the payload of the ingestion token creation endpoint
+type createTokenRequest struct {
+	Label string `json:"label"`
+}
+
+// createTokenResponse is the representation of a created ingestion token, holding its value
+type createTokenResponse struct {
+	room.Token
+	Value string `json:"token"`
+}
+
+// writeTokenError writes a JSON error response matching an ingestion token error
+func writeTokenError(w http.ResponseWriter, err error) {
+	if errors.Is(err, room.ErrTokenNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeRoomError(w, err)
+}
+
+// HandleListTokens returns the ingestion tokens of a room, without their value (requires room member or admin
+// Basic Auth)
+func (s *Server) HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := s.roomManager.ListTokens(rm.Name)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// HandleCreateToken creates an ingestion token for a room (requires room member or admin Basic Auth)
+// The response holds the token value, which is not returned afterwards.
+func (s *Server) HandleCreateToken(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	var req createTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+			return
+		}
+	}
+
+	token, value, err := s.roomManager.CreateToken(rm.Name, req.Label)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createTokenResponse{Token: token, Value: value})
+}
+
+// HandleDeleteToken revokes an ingestion token of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.roomManager.DeleteToken(rm.Name, r.PathValue("id")); err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
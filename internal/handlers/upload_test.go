@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/auth"
+	"github.com/ncarlier/imgcast/internal/config"
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/internal/storage"
+)
+
+// newTestServer creates a server storing its rooms in a temporary directory, with a "lobby" room whose member
+// is alice:secret
+func newTestServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+	dir := t.TempDir()
+	store := storage.New(dir)
+	if err := store.EnsureBaseDir(); err != nil {
+		t.Fatal(err)
+	}
+	manager := room.NewManager(store, auth.NewAuthenticator(filepath.Join(dir, storage.HtpasswdFilename)), false, nil)
+	if _, err := manager.ProvisionRoom("lobby", room.Options{Members: []room.Member{{Username: "alice", Password: "secret"}}}); err != nil {
+		t.Fatalf("ProvisionRoom: %v", err)
+	}
+	t.Cleanup(func() { manager.DeleteRoom("lobby") })
+
+	s := &Server{config: &config.Config{}, roomManager: manager, storage: store}
+	return s, s.routes()
+}
+
+// testPNG returns a small PNG image
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// upload sends a raw upload request as member of the lobby room
+func upload(handler http.Handler, target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, target, body)
+	req.SetBasicAuth("alice", "secret")
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRawUploadWithFormContentType(t *testing.T) {
+	s, handler := newTestServer(t)
+	img := testPNG(t)
+
+	// curl -X PUT --data-binary sends application/x-www-form-urlencoded by default
+	rec := upload(handler, "/lobby/live", "application/x-www-form-urlencoded", bytes.NewReader(img))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d (%s), want 200", rec.Code, rec.Body)
+	}
+	published, err := os.ReadFile(s.storage.GetRoomImagePath("lobby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(published, img) {
+		t.Errorf("published %d bytes, want the %d bytes uploaded", len(published), len(img))
+	}
+}
+
+func TestRawUploadScheduled(t *testing.T) {
+	s, handler := newTestServer(t)
+	publishAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	rec := upload(handler, "/lobby/live?publish_at="+publishAt, "application/x-www-form-urlencoded", bytes.NewReader(testPNG(t)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("scheduled upload: status %d (%s), want 202", rec.Code, rec.Body)
+	}
+	publications, err := s.roomManager.ListPublications("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(publications) != 1 {
+		t.Fatalf("%d scheduled publications, want 1", len(publications))
+	}
+	info, err := os.Stat(s.storage.GetPendingImagePath("lobby", publications[0].ID))
+	if err != nil || info.Size() == 0 {
+		t.Errorf("scheduled image not stored: %v", err)
+	}
+}
+
+func TestEmptyUploadRefused(t *testing.T) {
+	s, handler := newTestServer(t)
+
+	// A body of unknown length reading no byte
+	rec := upload(handler, "/lobby/live", "image/png", io.MultiReader())
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty upload: status %d, want 400", rec.Code)
+	}
+	if s.storage.HasImage("lobby", "") {
+		t.Error("empty image published")
+	}
+}
+
+func TestOversizedImageRefused(t *testing.T) {
+	s, handler := newTestServer(t)
+	if _, err := s.roomManager.UpdateSettings("lobby", room.Settings{OverlayText: "{{ .Room }}"}); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+
+	// A PNG header announcing 100000x100000 pixels, without pixels
+	ihdr := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 100_000), 100_000)
+	chunk := append([]byte("IHDR"), append(ihdr, 8, 2, 0, 0, 0)...)
+	bomb := binary.BigEndian.AppendUint32([]byte("\x89PNG\r\n\x1a\n"), 13)
+	bomb = binary.BigEndian.AppendUint32(append(bomb, chunk...), crc32.ChecksumIEEE(chunk))
+
+	rec := upload(handler, "/lobby/live", "image/png", bytes.NewReader(bomb))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized image: status %d (%s), want 413", rec.Code, rec.Body)
+	}
+	if s.storage.HasImage("lobby", "") {
+		t.Error("oversized image published")
+	}
+}
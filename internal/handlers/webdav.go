@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+// WebDAV methods not defined by net/http
+const (
+	methodPropfind = "PROPFIND"
+	methodLock     = "LOCK"
+	methodUnlock   = "UNLOCK"
+)
+
+// davLockTimeout is the lifetime advertised for WebDAV locks, which are never enforced
+const davLockTimeout = "Second-3600"
+
+// davMultistatus is the body of a PROPFIND response
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Namespace string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+// davResponse holds the properties of a resource of a PROPFIND response
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+// davPropstat holds the properties of a resource along with their status
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+// davProp holds the live properties of a resource
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength int64           `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	CreationDate  string          `xml:"D:creationdate,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified,omitempty"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+}
+
+// davResourceType tells collections from files
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+// davCollectionRoutes returns the WebDAV handlers of the collection of a room, listing its publication history
+func (s *Server) davCollectionRoutes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		http.MethodOptions: s.HandleDAVOptions,
+		methodPropfind:     s.HandleDAVPropfind,
+		methodLock:         s.HandleDAVLock,
+		methodUnlock:       s.HandleDAVUnlock,
+	}
+}
+
+// davFileRoutes returns the WebDAV handlers of the files of the collection of a room
+func (s *Server) davFileRoutes() map[string]http.HandlerFunc {
+	routes := s.davCollectionRoutes()
+	routes[http.MethodGet] = s.HandleDAVGet
+	routes[http.MethodPut] = s.HandleDAVPut
+	return routes
+}
+
+// HandleDAVOptions advertises the WebDAV support of the collection of a room
+// Clients probe it before authenticating, so it requires no credentials.
+func (s *Server) HandleDAVOptions(w http.ResponseWriter, r *http.Request) {
+	allowed := []string{http.MethodOptions, methodPropfind, methodLock, methodUnlock}
+	if r.PathValue("file") != "" {
+		allowed = append(allowed, http.MethodGet, http.MethodHead, http.MethodPut)
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	w.Header().Set("DAV", "1, 2")
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleDAVPropfind lists the publication history of a room as the files of its WebDAV collection (requires
+// room member or admin Basic Auth)
+func (s *Server) HandleDAVPropfind(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := s.roomManager.History(rm.Name)
+	if err != nil {
+		slog.Error("Failed to get room history", "room", rm.Name, "error", err)
+		http.Error(w, "Unable to list history", http.StatusInternalServerError)
+		return
+	}
+
+	collection := s.config.JoinPath("/" + rm.Name + "/dav/")
+	multistatus := davMultistatus{Namespace: "DAV:"}
+	if name := r.PathValue("file"); name != "" {
+		for _, entry := range entries {
+			if entry.Name == name {
+				multistatus.Responses = append(multistatus.Responses, davFileResponse(collection, entry))
+			}
+		}
+		if len(multistatus.Responses) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+	} else {
+		lastModified := time.Now()
+		if len(entries) > 0 {
+			lastModified = entries[0].PublishedAt
+		}
+		multistatus.Responses = append(multistatus.Responses, davResponse{
+			Href: davHref(collection),
+			Propstat: davPropstat{
+				Prop: davProp{
+					DisplayName:  path.Base(rm.Name),
+					ResourceType: davResourceType{Collection: &struct{}{}},
+					LastModified: lastModified.UTC().Format(http.TimeFormat),
+				},
+				Status: "HTTP/1.1 200 OK",
+			},
+		})
+		// Infinite depth is served as depth 1, the collection holding no sub-collection
+		if r.Header.Get("Depth") != "0" {
+			for _, entry := range entries {
+				multistatus.Responses = append(multistatus.Responses, davFileResponse(collection, entry))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(multistatus); err != nil {
+		slog.Error("Failed to encode PROPFIND response", "room", rm.Name, "error", err)
+	}
+}
+
+// HandleDAVGet serves an image of the publication history of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleDAVGet(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	entry, imagePath, err := s.roomManager.HistoryImage(rm.Name, r.PathValue("file"))
+	if errors.Is(err, room.ErrHistoryNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get history image", "room", rm.Name, "error", err)
+		http.Error(w, "Unable to get image", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(imagePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	if contentType := imaging.ContentType(entry.Format); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", davETag(entry))
+	http.ServeContent(w, r, entry.Name, entry.PublishedAt, file)
+}
+
+// HandleDAVPut publishes a file written to the WebDAV collection of a room as its main image, keeping it in
+// the room history under its file name (requires room member or admin Basic Auth)
+func (s *Server) HandleDAVPut(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+	username, _, _ := r.BasicAuth()
+	name := r.PathValue("file")
+
+	if s.config.MaxUploadSize > 0 {
+		if r.ContentLength > s.config.MaxUploadSize {
+			writeUploadError(w, &http.MaxBytesError{Limit: s.config.MaxUploadSize})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxUploadSize)
+	}
+
+	// Desktop clients write hidden files ("._photo.jpg", ".DS_Store") along with images, and create empty files
+	// before writing them: these are accepted but not published
+	if strings.HasPrefix(name, ".") || r.ContentLength == 0 {
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	_, _, err := s.roomManager.HistoryImage(rm.Name, name)
+	exists := err == nil
+	published, err := s.roomManager.PublishFile(rm, "", name, r.Body)
+	if errors.Is(err, room.ErrEmptyImage) {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if err != nil {
+		writePublishError(w, rm, "", username, err)
+		return
+	}
+	if !published {
+		slog.Info("Duplicate image skipped", "room", rm.Name, "file", name, "user", username)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	slog.Info("Image uploaded via WebDAV", "room", rm.Name, "file", name, "user", username)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleDAVLock grants a lock on the WebDAV collection of a room or on one of its files (requires room member
+// or admin Basic Auth)
+// Desktop clients only mount writable collections supporting locks, but publications never conflict: locks
+// are granted without being enforced.
+func (s *Server) HandleDAVLock(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.Header.Get("If")
+	if start, end := strings.Index(token, "<"), strings.Index(token, ">"); start >= 0 && end > start {
+		// Lock refresh
+		token = token[start+1 : end]
+	} else {
+		b := make([]byte, 16)
+		rand.Read(b)
+		token = "opaquelocktoken:" + hex.EncodeToString(b)
+	}
+
+	root := s.config.JoinPath("/" + rm.Name + "/dav/")
+	if name := r.PathValue("file"); name != "" {
+		root += name
+	}
+	var href strings.Builder
+	xml.EscapeText(&href, []byte(davHref(root)))
+	var escapedToken strings.Builder
+	xml.EscapeText(&escapedToken, []byte(token))
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.Header().Set("Lock-Token", "<"+token+">")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `%s<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope><D:depth>0</D:depth>`+
+		`<D:timeout>%s</D:timeout><D:locktoken><D:href>%s</D:href></D:locktoken><D:lockroot><D:href>%s</D:href></D:lockroot>`+
+		`</D:activelock></D:lockdiscovery></D:prop>`, xml.Header, davLockTimeout, escapedToken.String(), href.String())
+}
+
+// HandleDAVUnlock releases a lock granted by HandleDAVLock (requires room member or admin Basic Auth)
+func (s *Server) HandleDAVUnlock(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.lookupRoomMember(w, r); !ok {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// davFileResponse returns the PROPFIND properties of an image of the publication history of a room
+func davFileResponse(collection string, entry room.HistoryEntry) davResponse {
+	return davResponse{
+		Href: davHref(collection + entry.Name),
+		Propstat: davPropstat{
+			Prop: davProp{
+				DisplayName:   entry.Name,
+				ContentLength: entry.Size,
+				ContentType:   imaging.ContentType(entry.Format),
+				CreationDate:  entry.PublishedAt.UTC().Format(time.RFC3339),
+				LastModified:  entry.PublishedAt.UTC().Format(http.TimeFormat),
+				ETag:          davETag(entry),
+			},
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// davHref returns the escaped URL path of a WebDAV resource
+func davHref(resourcePath string) string {
+	return (&url.URL{Path: resourcePath}).EscapedPath()
+}
+
+// davETag returns the entity tag of an image of the publication history of a room
+func davETag(entry room.HistoryEntry) string {
+	return `"` + entry.ID + `"`
+}
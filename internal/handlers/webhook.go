@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+// writeWebhookError writes a JSON error response matching a webhook error
+func writeWebhookError(w http.ResponseWriter, err error) {
+	if errors.Is(err, room.ErrWebhookNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeRoomError(w, err)
+}
+
+// HandleListWebhooks returns the webhook subscriptions of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	webhooks, err := s.roomManager.ListWebhooks(rm.Name)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, webhooks)
+}
+
+// HandleAddWebhook subscribes a webhook to the events of a room (requires room member or admin Basic Auth)
+// The response holds the webhook secret, which is not returned afterwards.
+func (s *Server) HandleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	var webhook room.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
+	}
+
+	webhook, err := s.roomManager.AddWebhook(rm.Name, webhook)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+// HandleDeleteWebhook removes a webhook subscription of a room (requires room member or admin Basic Auth)
+func (s *Server) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.roomManager.DeleteWebhook(rm.Name, r.PathValue("id")); err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListWebhookDeliveries returns the delivery log of a webhook of a room (requires room member or admin
+// Basic Auth)
+func (s *Server) HandleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	rm, ok := s.lookupRoomMember(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := s.roomManager.WebhookDeliveries(rm.Name, r.PathValue("id"))
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
@@ -0,0 +1,308 @@
+// Package hook runs the pre-publish hooks validating or transforming images before they go live
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+const (
+	// DefaultTimeout is the timeout of a hook without explicit timeout
+	DefaultTimeout = 10 * time.Second
+	// maxReasonLength is the maximum length of a rejection reason
+	maxReasonLength = 256
+	// maxOutputSize is the maximum size of the image returned by a hook
+	maxOutputSize = 64 << 20
+)
+
+// ErrHookFailed is the error wrapped by hook execution errors, as opposed to rejections
+var ErrHookFailed = errors.New("publish hook failed")
+
+// errOutputTooLarge is returned when writing beyond the output size limit of a hook
+var errOutputTooLarge = errors.New("output too large")
+
+// RejectedError is returned when a hook vetoes an image
+type RejectedError struct {
+	Hook   string
+	Reason string
+}
+
+// Error returns the rejection message
+func (e *RejectedError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("image rejected by %s", e.Hook)
+	}
+	return fmt.Sprintf("image rejected by %s: %s", e.Hook, e.Reason)
+}
+
+// Hook is an external command or an HTTP endpoint receiving the image about to be published
+// A hook may return a replacement image, leave the image unchanged, or reject it with a reason.
+type Hook struct {
+	Name string
+	// Command is the command and its arguments, run with the image on stdin (exclusive with URL)
+	Command []string
+	// URL is the address the image is posted to (exclusive with Command)
+	URL     string
+	Timeout time.Duration
+	// Rooms are the patterns of the room names the hook applies to (empty means all)
+	Rooms []string
+}
+
+// hookConfig is the representation of a hook in the hooks file
+type hookConfig struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	URL     string   `json:"url"`
+	Timeout string   `json:"timeout"`
+	Rooms   []string `json:"rooms"`
+}
+
+// Chain is the ordered list of the pre-publish hooks
+type Chain struct {
+	hooks  []Hook
+	client *http.Client
+}
+
+// NewChain creates a chain running the given hooks in order
+func NewChain(hooks []Hook) *Chain {
+	return &Chain{hooks: hooks, client: &http.Client{}}
+}
+
+// Load reads a chain from a JSON file listing the hooks
+func Load(filename string) (*Chain, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks file: %w", err)
+	}
+	var configs []hookConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks file: %w", err)
+	}
+
+	hooks := make([]Hook, 0, len(configs))
+	for i, config := range configs {
+		hook := Hook{Name: config.Name, Command: config.Command, URL: config.URL, Timeout: DefaultTimeout, Rooms: config.Rooms}
+		if hook.Name == "" {
+			hook.Name = fmt.Sprintf("hook #%d", i+1)
+		}
+		if (len(hook.Command) == 0) == (hook.URL == "") {
+			return nil, fmt.Errorf("invalid %s: exactly one of command and url is required", hook.Name)
+		}
+		if hook.URL != "" {
+			if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid %s: url must be an http or https URL", hook.Name)
+			}
+		}
+		if config.Timeout != "" {
+			if hook.Timeout, err = time.ParseDuration(config.Timeout); err != nil || hook.Timeout <= 0 {
+				return nil, fmt.Errorf("invalid %s: timeout must be a positive duration", hook.Name)
+			}
+		}
+		for _, pattern := range hook.Rooms {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid %s: invalid room pattern %q", hook.Name, pattern)
+			}
+		}
+		hooks = append(hooks, hook)
+	}
+	return NewChain(hooks), nil
+}
+
+// Len returns the number of hooks of the chain
+func (c *Chain) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.hooks)
+}
+
+// Applies reports whether some hooks of the chain apply to a room
+func (c *Chain) Applies(roomName string) bool {
+	if c == nil {
+		return false
+	}
+	for _, hook := range c.hooks {
+		if hook.applies(roomName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run passes an image through the hooks applying to a room, in order, and returns the image to publish
+// A rejection is returned as a *RejectedError.
+func (c *Chain) Run(ctx context.Context, roomName, slot string, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	for _, hook := range c.hooks {
+		if !hook.applies(roomName) {
+			continue
+		}
+		var output []byte
+		var err error
+		if hook.URL != "" {
+			output, err = c.post(ctx, hook, roomName, slot, data)
+		} else {
+			output, err = run(ctx, hook, roomName, slot, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(output) > 0 {
+			data = output
+		}
+	}
+	return data, nil
+}
+
+// applies reports whether a hook applies to a room
+func (h Hook) applies(roomName string) bool {
+	if len(h.Rooms) == 0 {
+		return true
+	}
+	for _, pattern := range h.Rooms {
+		if matched, _ := path.Match(pattern, roomName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs a command hook with the image on stdin
+// A zero exit status accepts the image, replaced by the standard output unless empty. Any other status rejects
+// it, with the standard error as reason. A standard output that is not an image is a failure.
+func run(ctx context.Context, hook Hook, roomName, slot string, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	// The command is killed as soon as its output exceeds the size limit
+	abortCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	cmd := exec.CommandContext(abortCtx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(), "IMGCAST_ROOM="+roomName, "IMGCAST_SLOT="+slot)
+	cmd.Stdin = bytes.NewReader(data)
+	stdout := &limitedBuffer{limit: maxOutputSize, abort: abort}
+	stderr := &limitedBuffer{limit: maxReasonLength * 4}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// Pipes kept open by processes started by the command do not delay its completion
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if stdout.exceeded {
+		return nil, fmt.Errorf("%w: %s: output too large", ErrHookFailed, hook.Name)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: %s: timed out after %s", ErrHookFailed, hook.Name, hook.Timeout)
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrHookFailed, hook.Name, ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, &RejectedError{Hook: hook.Name, Reason: reason(stderr.buf.String())}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrHookFailed, hook.Name, err)
+	}
+	output := stdout.buf.Bytes()
+	if len(output) > 0 && imaging.DetectFormat(output) == "" {
+		return nil, fmt.Errorf("%w: %s: output is not an image", ErrHookFailed, hook.Name)
+	}
+	return output, nil
+}
+
+// limitedBuffer is a buffer holding at most limit bytes
+// Beyond the limit, writes fail and the abort function is called if set, or are discarded otherwise. The buffer
+// is not embedded, so that copies go through Write rather than bytes.Buffer.ReadFrom.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	abort    func()
+	exceeded bool
+}
+
+// Write appends data to the buffer within the size limit
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) <= b.limit {
+		return b.buf.Write(p)
+	}
+	b.exceeded = true
+	if b.abort == nil {
+		b.buf.Write(p[:b.limit-b.buf.Len()])
+		return len(p), nil
+	}
+	b.abort()
+	return 0, errOutputTooLarge
+}
+
+// post posts the image to an HTTP hook
+// A 2xx response accepts the image, replaced by the response body when it is an image. A 4xx response rejects
+// it, with the response body as reason. Other responses are failures.
+func (c *Chain) post(ctx context.Context, hook Hook, roomName, slot string, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrHookFailed, hook.Name, err)
+	}
+	contentType := "application/octet-stream"
+	if format := imaging.DetectFormat(data); format != "" {
+		contentType = imaging.ContentType(format)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "imgcast")
+	req.Header.Set("X-Imgcast-Room", roomName)
+	req.Header.Set("X-Imgcast-Slot", slot)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrHookFailed, hook.Name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOutputSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrHookFailed, hook.Name, err)
+	}
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if len(body) > maxOutputSize {
+			return nil, fmt.Errorf("%w: %s: response too large", ErrHookFailed, hook.Name)
+		}
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if !strings.HasPrefix(mediaType, "image/") {
+			return nil, nil
+		}
+		return body, nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return nil, &RejectedError{Hook: hook.Name, Reason: reason(string(body))}
+	default:
+		return nil, fmt.Errorf("%w: %s: unexpected status %s", ErrHookFailed, hook.Name, resp.Status)
+	}
+}
+
+// reason returns the first line of a hook output as rejection reason, truncated to a reasonable length
+func reason(output string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	line = strings.TrimSpace(line)
+	if len(line) > maxReasonLength {
+		line = line[:maxReasonLength]
+	}
+	return line
+}
@@ -0,0 +1,123 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		output  string
+		reason  string
+		failure bool
+	}{
+		{name: "accept", command: []string{"true"}, output: "image"},
+		{name: "replace", command: []string{"sh", "-c", "cat >/dev/null; printf GIF89a"}, output: "GIF89a"},
+		{name: "environment", command: []string{"sh", "-c", `printf "GIF89a$IMGCAST_ROOM/$IMGCAST_SLOT"`}, output: "GIF89alobby/cam1"},
+		{name: "text output", command: []string{"sh", "-c", "cat >/dev/null; echo OK"}, failure: true},
+		{name: "reject", command: []string{"sh", "-c", "echo 'not safe for work' >&2; exit 1"}, reason: "not safe for work"},
+		{name: "missing", command: []string{"/nonexistent/hook"}, failure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := NewChain([]Hook{{Name: tt.name, Command: tt.command, Timeout: 5 * time.Second}})
+			output, err := chain.Run(context.Background(), "lobby", "cam1", []byte("image"))
+
+			var rejected *RejectedError
+			switch {
+			case tt.reason != "":
+				if !errors.As(err, &rejected) || rejected.Reason != tt.reason {
+					t.Errorf("got %v, want rejection %q", err, tt.reason)
+				}
+			case tt.failure:
+				if !errors.Is(err, ErrHookFailed) {
+					t.Errorf("got %v, want ErrHookFailed", err)
+				}
+			case err != nil || string(output) != tt.output:
+				t.Errorf("got %q, %v; want %q", output, err, tt.output)
+			}
+		})
+	}
+}
+
+func TestRunCommandOutputLimit(t *testing.T) {
+	// A command writing endlessly is killed once its output exceeds the limit, before its timeout
+	chain := NewChain([]Hook{{Name: "endless", Command: []string{"yes"}, Timeout: time.Minute}})
+	start := time.Now()
+	_, err := chain.Run(context.Background(), "lobby", "", []byte("image"))
+	if !errors.Is(err, ErrHookFailed) || !strings.Contains(err.Error(), "output too large") {
+		t.Errorf("got %v, want output too large", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("command killed after %s", elapsed)
+	}
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	chain := NewChain([]Hook{{Name: "slow", Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond}})
+	_, err := chain.Run(context.Background(), "lobby", "", []byte("image"))
+	if !errors.Is(err, ErrHookFailed) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want timeout", err)
+	}
+}
+
+func TestRunCommandCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	chain := NewChain([]Hook{{Name: "slow", Command: []string{"sleep", "10"}, Timeout: time.Minute}})
+	_, err := chain.Run(ctx, "lobby", "", []byte("image"))
+	if !errors.Is(err, ErrHookFailed) || !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want cancellation", err)
+	}
+}
+
+func TestPost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch string(body) {
+		case "reject":
+			http.Error(w, "rejected by moderation", http.StatusUnprocessableEntity)
+		case "replace":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, "replaced")
+		case "fail":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	chain := NewChain([]Hook{{Name: "moderation", URL: srv.URL, Timeout: 5 * time.Second}})
+
+	if output, err := chain.Run(context.Background(), "lobby", "", []byte("accept")); err != nil || string(output) != "accept" {
+		t.Errorf("accept: got %q, %v", output, err)
+	}
+	if output, err := chain.Run(context.Background(), "lobby", "", []byte("replace")); err != nil || string(output) != "replaced" {
+		t.Errorf("replace: got %q, %v", output, err)
+	}
+	var rejected *RejectedError
+	if _, err := chain.Run(context.Background(), "lobby", "", []byte("reject")); !errors.As(err, &rejected) || rejected.Reason != "rejected by moderation" {
+		t.Errorf("reject: got %v", err)
+	}
+	if _, err := chain.Run(context.Background(), "lobby", "", []byte("fail")); !errors.Is(err, ErrHookFailed) {
+		t.Errorf("fail: got %v", err)
+	}
+}
+
+func TestHookRooms(t *testing.T) {
+	chain := NewChain([]Hook{{Name: "acme", Command: []string{"false"}, Timeout: time.Second, Rooms: []string{"acme/*"}}})
+	if chain.Applies("lobby") || !chain.Applies("acme/lobby") {
+		t.Error("hook applies to the wrong rooms")
+	}
+	if _, err := chain.Run(context.Background(), "lobby", "", []byte("image")); err != nil {
+		t.Errorf("hook run on another room: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// DifferenceHash computes the perceptual difference hash (dHash) of an image
+// Similar images have hashes differing by a small number of bits, see HashDistance.
+func DifferenceHash(img image.Image) uint64 {
+	small := Resize(img, Options{Width: 9, Height: 8, Fit: "fill"})
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(x+1, y)).(color.Gray).Y
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance returns the number of bits differing between two difference hashes (0 to 64)
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
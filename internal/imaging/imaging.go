@@ -0,0 +1,253 @@
+// Package imaging produces resized and re-encoded renditions of the uploaded images
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register the WebP decoder
+)
+
+const (
+	// MaxDimension is the maximum width or height of a rendition
+	MaxDimension = 4096
+	// MaxPixels is the maximum number of pixels of a decoded image, bounding the memory used to decode it
+	MaxPixels = 50_000_000
+	// jpegQuality is the quality of the JPEG renditions
+	jpegQuality = 85
+)
+
+var (
+	// ErrInvalidOptions is the error wrapped by all rendition options validation errors
+	ErrInvalidOptions = errors.New("invalid rendition options")
+	// ErrUnsupportedImage is returned when rendering an image in a format that cannot be decoded
+	ErrUnsupportedImage = errors.New("unsupported image format")
+	// ErrImageTooLarge is returned when decoding an image of more than MaxPixels pixels, along with
+	// ErrUnsupportedImage
+	ErrImageTooLarge = errors.New("image too large")
+)
+
+// Dimensions are the widths and heights renditions can be requested in, so that each image has few renditions
+var Dimensions = []int{32, 64, 128, 160, 240, 320, 480, 640, 800, 960, 1024, 1280, 1600, 1920, 2560, 3840, MaxDimension}
+
+// decodableFormats are the image formats renditions can be produced from
+var decodableFormats = []string{"jpeg", "png", "gif", "webp"}
+
+// FitModes are the supported ways of fitting an image into the requested box (empty means "scale-down")
+// "scale-down" and "contain" keep the image within the box, "scale-down" never enlarging it,
+// "cover" fills the box and crops the overflow, and "fill" stretches the image to the box.
+var FitModes = []string{"scale-down", "contain", "cover", "fill"}
+
+// encoders are the output formats renditions can be encoded to
+// WebP and AVIF have no pure Go encoder: they are only served when the original is in that format.
+var encoders = map[string]func(w io.Writer, img image.Image) error{
+	"jpeg": func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: jpegQuality})
+	},
+	"png": func(w io.Writer, img image.Image) error {
+		return png.Encode(w, img)
+	},
+	"gif": func(w io.Writer, img image.Image) error {
+		return gif.Encode(w, img, nil)
+	},
+}
+
+// contentTypes maps the image formats to their media type
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+// Options describes a rendition of an image
+type Options struct {
+	Width  int
+	Height int
+	Fit    string
+	// Format is the output format ("jpeg", "png", "gif", "webp" or "avif"), empty to negotiate it
+	Format string
+}
+
+// ParseOptions reads the rendition options from the "w", "h", "fit" and "format" query parameters
+func ParseOptions(query url.Values) (Options, error) {
+	var opts Options
+	var err error
+	if opts.Width, err = parseDimension(query.Get("w")); err != nil {
+		return Options{}, fmt.Errorf("%w: w %w", ErrInvalidOptions, err)
+	}
+	if opts.Height, err = parseDimension(query.Get("h")); err != nil {
+		return Options{}, fmt.Errorf("%w: h %w", ErrInvalidOptions, err)
+	}
+
+	opts.Fit = query.Get("fit")
+	if opts.Fit != "" && !slices.Contains(FitModes, opts.Fit) {
+		return Options{}, fmt.Errorf("%w: fit must be one of %v", ErrInvalidOptions, FitModes)
+	}
+
+	opts.Format = query.Get("format")
+	if opts.Format == "jpg" {
+		opts.Format = "jpeg"
+	}
+	if opts.Format != "" && ContentType(opts.Format) == "" {
+		return Options{}, fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, opts.Format)
+	}
+	return opts, nil
+}
+
+// Resizes reports whether the options change the image dimensions
+func (o Options) Resizes() bool {
+	return o.Width > 0 || o.Height > 0
+}
+
+// Key returns a file name fragment identifying the rendition
+func (o Options) Key() string {
+	fit := o.Fit
+	if fit == "" {
+		fit = "scale-down"
+	}
+	return fmt.Sprintf("%dx%d-%s.%s", o.Width, o.Height, fit, o.Format)
+}
+
+// ContentType returns the media type of an image format, or an empty string if the format is unknown
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// CanDecode reports whether renditions can be produced from images in a format
+func CanDecode(format string) bool {
+	return slices.Contains(decodableFormats, format)
+}
+
+// CanEncode reports whether renditions can be encoded to a format
+func CanEncode(format string) bool {
+	_, ok := encoders[format]
+	return ok
+}
+
+// Render decodes an image, resizes it according to the options, and encodes it in the options format
+func Render(w io.Writer, r io.Reader, opts Options) error {
+	encode, ok := encoders[opts.Format]
+	if !ok {
+		return fmt.Errorf("%w: unable to encode %q images", ErrInvalidOptions, opts.Format)
+	}
+
+	src, err := Decode(r)
+	if err != nil {
+		return err
+	}
+
+	return encode(w, Resize(src, opts))
+}
+
+// Decode decodes an image in one of the decodable formats
+// The image dimensions are read first, so that images of more than MaxPixels pixels are refused before their
+// pixels are allocated.
+func Decode(r io.Reader) (image.Image, error) {
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > MaxPixels/config.Height {
+		return nil, fmt.Errorf("%w: %w: %dx%d pixels, at most %d", ErrUnsupportedImage, ErrImageTooLarge, config.Width, config.Height, MaxPixels)
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedImage, err)
+	}
+	return img, nil
+}
+
+// Encode encodes an image in one of the encodable formats
+func Encode(w io.Writer, img image.Image, format string) error {
+	encode, ok := encoders[format]
+	if !ok {
+		return fmt.Errorf("%w: unable to encode %q images", ErrInvalidOptions, format)
+	}
+	return encode(w, img)
+}
+
+// Resize scales an image into the box of the options, ignoring their format
+func Resize(src image.Image, opts Options) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if !opts.Resizes() || srcW == 0 || srcH == 0 {
+		return src
+	}
+
+	// A missing dimension follows the image aspect ratio
+	boxW, boxH := opts.Width, opts.Height
+	if boxW == 0 {
+		boxW = max(1, srcW*boxH/srcH)
+	}
+	if boxH == 0 {
+		boxH = max(1, srcH*boxW/srcW)
+	}
+
+	dstW, dstH := boxW, boxH
+	crop := bounds
+	switch opts.Fit {
+	case "fill":
+	case "cover":
+		// Crop the source to the box aspect ratio, around its center
+		if srcW*boxH > boxW*srcH {
+			cropW := srcH * boxW / boxH
+			crop.Min.X += (srcW - cropW) / 2
+			crop.Max.X = crop.Min.X + cropW
+		} else {
+			cropH := srcW * boxH / boxW
+			crop.Min.Y += (srcH - cropH) / 2
+			crop.Max.Y = crop.Min.Y + cropH
+		}
+	default:
+		if srcW*boxH > boxW*srcH {
+			dstH = max(1, srcH*boxW/srcW)
+		} else {
+			dstW = max(1, srcW*boxH/srcH)
+		}
+		if opts.Fit != "contain" && dstW >= srcW {
+			return src
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
+
+// flatten composes an image with transparency onto a white background, for formats without alpha channel
+func flatten(img image.Image) image.Image {
+	if _, ok := img.(*image.YCbCr); ok {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// parseDimension parses an optional rendition width or height
+func parseDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || !slices.Contains(Dimensions, n) {
+		return 0, fmt.Errorf("must be one of %v", Dimensions)
+	}
+	return n, nil
+}
@@ -0,0 +1,78 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/url"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Options
+		err   bool
+	}{
+		{query: "", want: Options{}},
+		{query: "w=640", want: Options{Width: 640}},
+		{query: "w=320&h=320&fit=cover&format=jpg", want: Options{Width: 320, Height: 320, Fit: "cover", Format: "jpeg"}},
+		{query: "w=4096", want: Options{Width: MaxDimension}},
+		{query: "w=641", err: true},
+		{query: "h=1", err: true},
+		{query: "w=0", err: true},
+		{query: "w=8192", err: true},
+		{query: "w=abc", err: true},
+		{query: "fit=stretch", err: true},
+		{query: "format=bmp", err: true},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		opts, err := ParseOptions(query)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("ParseOptions(%q) = %v, want ErrInvalidOptions", tt.query, err)
+			}
+			continue
+		}
+		if err != nil || opts != tt.want {
+			t.Errorf("ParseOptions(%q) = %+v, %v, want %+v", tt.query, opts, err, tt.want)
+		}
+	}
+}
+
+// pngHeader returns the signature and header chunk of a PNG image of the given dimensions, without pixels
+func pngHeader(width, height uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8-bit RGB
+	chunk := append([]byte("IHDR"), ihdr...)
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)))
+	data = append(data, chunk...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(chunk))
+}
+
+func TestDecodePixelLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 300 || size.Y != 200 {
+		t.Errorf("decoded %v image, want 300x200", size)
+	}
+
+	// A few bytes announcing 100000x100000 pixels are refused before allocating them
+	_, err = Decode(bytes.NewReader(pngHeader(100_000, 100_000)))
+	if !errors.Is(err, ErrImageTooLarge) || !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("Decode of an oversized image = %v, want ErrImageTooLarge and ErrUnsupportedImage", err)
+	}
+}
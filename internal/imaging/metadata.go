@@ -0,0 +1,247 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"slices"
+)
+
+// Metadata kinds reported as removed
+const (
+	MetadataExif    = "exif"
+	MetadataXMP     = "xmp"
+	MetadataIPTC    = "iptc"
+	MetadataComment = "comment"
+	MetadataText    = "text"
+)
+
+// exifOrientationTag and exifGPSTag are the IFD0 tags of the EXIF orientation and GPS information
+const (
+	exifOrientationTag = 0x0112
+	exifGPSTag         = 0x8825
+)
+
+var (
+	jpegSignature = []byte{0xFF, 0xD8}
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader    = []byte("Exif\x00\x00")
+	xmpHeaders    = [][]byte{[]byte("http://ns.adobe.com/xap/1.0/\x00"), []byte("http://ns.adobe.com/xmp/extension/\x00")}
+	iptcHeader    = []byte("Photoshop 3.0\x00")
+)
+
+// MetadataReport describes the metadata processing applied to an image
+type MetadataReport struct {
+	// Removed lists the kinds of metadata removed ("exif", "xmp", "iptc", "comment", "text")
+	Removed []string `json:"removed"`
+	// GPS reports whether the removed EXIF data held a location
+	GPS bool `json:"gps,omitempty"`
+	// Orientation is the EXIF orientation applied to the image pixels (zero if none)
+	Orientation int `json:"orientation,omitempty"`
+}
+
+// Clean applies the EXIF orientation of a JPEG image to its pixels and strips the metadata of JPEG and PNG images
+// Applying the orientation re-encodes the image, which drops all its metadata. Images in other formats are
+// returned unchanged.
+func Clean(data []byte, applyOrientation, stripMetadata bool) ([]byte, MetadataReport, error) {
+	stripped, report := strip(data)
+	if applyOrientation {
+		if orientation := jpegOrientation(data); orientation > 1 && orientation <= 8 {
+			img, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, MetadataReport{}, err
+			}
+			var oriented bytes.Buffer
+			if err := jpeg.Encode(&oriented, orient(img, orientation), &jpeg.Options{Quality: 90}); err != nil {
+				return nil, MetadataReport{}, fmt.Errorf("failed to encode image: %w", err)
+			}
+			report.Orientation = orientation
+			return oriented.Bytes(), report, nil
+		}
+	}
+	if !stripMetadata {
+		return data, MetadataReport{Removed: []string{}}, nil
+	}
+	return stripped, report, nil
+}
+
+// strip removes the metadata segments of a JPEG image or the metadata chunks of a PNG image
+func strip(data []byte) ([]byte, MetadataReport) {
+	report := MetadataReport{Removed: []string{}}
+	remove := func(kind string) {
+		if !slices.Contains(report.Removed, kind) {
+			report.Removed = append(report.Removed, kind)
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(data, jpegSignature):
+		out := append([]byte{}, jpegSignature...)
+		err := walkJPEG(data, func(marker byte, segment, payload []byte) {
+			switch {
+			case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+				_, gps := parseExif(payload[len(exifHeader):])
+				report.GPS = report.GPS || gps
+				remove(MetadataExif)
+			case marker == 0xE1 && slices.ContainsFunc(xmpHeaders, func(h []byte) bool { return bytes.HasPrefix(payload, h) }):
+				remove(MetadataXMP)
+			case marker == 0xED && bytes.HasPrefix(payload, iptcHeader):
+				remove(MetadataIPTC)
+			case marker == 0xFE:
+				remove(MetadataComment)
+			default:
+				out = append(out, segment...)
+			}
+		})
+		if err != nil {
+			return data, MetadataReport{Removed: []string{}}
+		}
+		return out, report
+
+	case bytes.HasPrefix(data, pngSignature):
+		out := append([]byte{}, pngSignature...)
+		for i := len(pngSignature); i+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			end := i + 12 + length
+			if end > len(data) {
+				return data, MetadataReport{Removed: []string{}}
+			}
+			chunkType, payload := string(data[i+4:i+8]), data[i+8:i+8+length]
+			switch chunkType {
+			case "eXIf":
+				_, gps := parseExif(payload)
+				report.GPS = report.GPS || gps
+				remove(MetadataExif)
+			case "iTXt":
+				if bytes.HasPrefix(payload, []byte("XML:com.adobe.xmp\x00")) {
+					remove(MetadataXMP)
+				} else {
+					remove(MetadataText)
+				}
+			case "tEXt", "zTXt":
+				remove(MetadataText)
+			default:
+				out = append(out, data[i:end]...)
+			}
+			i = end
+		}
+		return out, report
+	}
+
+	return data, report
+}
+
+// walkJPEG calls fn for each segment of a JPEG image preceding the image data, which is passed as is
+func walkJPEG(data []byte, fn func(marker byte, segment, payload []byte)) error {
+	for i := len(jpegSignature); i < len(data); {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return fmt.Errorf("invalid JPEG segment at offset %d", i)
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || i+4 > len(data) {
+			// Start of scan: the rest is the entropy-coded image data
+			fn(marker, data[i:], nil)
+			return nil
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			return fmt.Errorf("truncated JPEG segment at offset %d", i)
+		}
+		fn(marker, data[i:end], data[i+4:end])
+		i = end
+	}
+	return nil
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, or zero if unknown
+func jpegOrientation(data []byte) int {
+	if !bytes.HasPrefix(data, jpegSignature) {
+		return 0
+	}
+	orientation := 0
+	walkJPEG(data, func(marker byte, _, payload []byte) {
+		if marker == 0xE1 && bytes.HasPrefix(payload, exifHeader) && orientation == 0 {
+			orientation, _ = parseExif(payload[len(exifHeader):])
+		}
+	})
+	return orientation
+}
+
+// parseExif reads the orientation and the presence of GPS information from EXIF (TIFF) data
+func parseExif(tiff []byte) (orientation int, gps bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 0, false
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		switch order.Uint16(tiff[entry:]) {
+		case exifOrientationTag:
+			orientation = int(order.Uint16(tiff[entry+8:]))
+		case exifGPSTag:
+			gps = true
+		}
+	}
+	return orientation, gps
+}
+
+// orient transforms an image according to an EXIF orientation
+func orient(src image.Image, orientation int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
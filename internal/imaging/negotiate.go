@@ -0,0 +1,85 @@
+package imaging
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// preferredFormats are the rendition formats by decreasing preference, when the original format is not acceptable
+var preferredFormats = []string{"avif", "webp", "jpeg", "png"}
+
+// DetectFormat returns the format of encoded image data from its first bytes, or an empty string if unknown
+func DetectFormat(header []byte) string {
+	// AVIF files are ISO BMFF containers, not sniffed by the standard library
+	if len(header) >= 12 && string(header[4:8]) == "ftyp" &&
+		(bytes.Equal(header[8:12], []byte("avif")) || bytes.Equal(header[8:12], []byte("avis"))) {
+		return "avif"
+	}
+
+	contentType := http.DetectContentType(header)
+	for format, ct := range contentTypes {
+		if ct == contentType {
+			return format
+		}
+	}
+	return ""
+}
+
+// DetectFileFormat returns the format of an image file, or an empty string if unknown
+func DetectFileFormat(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return DetectFormat(header[:n]), nil
+}
+
+// Negotiate picks the rendition format of an image from the Accept header of the request
+// The original format is kept when acceptable and when it can be served as is or re-encoded, otherwise
+// the first acceptable format that can be encoded is used, falling back to JPEG.
+func Negotiate(accept, original string, resizing bool) string {
+	candidates := append([]string{original}, preferredFormats...)
+	for _, format := range candidates {
+		if format == "" || !accepts(accept, ContentType(format)) {
+			continue
+		}
+		if CanEncode(format) || (format == original && !resizing) {
+			return format
+		}
+	}
+	return "jpeg"
+}
+
+// accepts reports whether an Accept header allows a media type (an empty header allows everything)
+func accepts(accept, mediaType string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	category, _, _ := strings.Cut(mediaType, "/")
+	for _, mediaRange := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(mediaRange, ";")
+		name = strings.TrimSpace(name)
+		if name != mediaType && name != category+"/*" && name != "*/*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// DefaultWatermarkScale is the default width of a watermark, relative to the image width
+	DefaultWatermarkScale = 0.2
+	// overlayMargin is the distance between an overlay and the image edges, relative to the smallest image dimension
+	overlayMargin = 0.02
+)
+
+// Positions are the supported overlay positions (empty means "bottom-right")
+var Positions = []string{"top-left", "top-right", "bottom-left", "bottom-right", "center"}
+
+// hexColorRegex matches the #rgb, #rrggbb and #rrggbbaa color notations
+var hexColorRegex = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// overlayFont is the font of the text overlays
+var overlayFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// Watermark is an image stamped onto another one
+type Watermark struct {
+	Image    image.Image
+	Position string
+	// Opacity is between 0 (invisible) and 1 (opaque)
+	Opacity float64
+	// Scale is the watermark width relative to the image width
+	Scale float64
+}
+
+// Text is a text stamped onto an image
+type Text struct {
+	// Text may span several lines
+	Text     string
+	Position string
+	Color    color.Color
+	// Size is the font size in pixels, zero for a size relative to the image height
+	Size float64
+}
+
+// Composite returns a copy of an image with a watermark and a text stamped onto it, both optional
+func Composite(src image.Image, watermark *Watermark, text *Text) (image.Image, error) {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	margin := int(float64(min(bounds.Dx(), bounds.Dy())) * overlayMargin)
+
+	if watermark != nil && watermark.Image != nil {
+		scale := watermark.Scale
+		if scale <= 0 {
+			scale = DefaultWatermarkScale
+		}
+		mark := Resize(watermark.Image, Options{Width: max(1, int(float64(bounds.Dx())*scale)), Fit: "contain"})
+		at := place(dst.Bounds(), mark.Bounds().Size(), margin, watermark.Position)
+		opacity := uint8(255 * min(max(watermark.Opacity, 0), 1))
+		draw.DrawMask(dst, mark.Bounds().Sub(mark.Bounds().Min).Add(at), mark, mark.Bounds().Min,
+			image.NewUniform(color.Alpha{A: opacity}), image.Point{}, draw.Over)
+	}
+
+	if text != nil && strings.TrimSpace(text.Text) != "" {
+		if err := drawText(dst, text, margin); err != nil {
+			return nil, err
+		}
+	}
+
+	return dst, nil
+}
+
+// ParseColor parses a color in the #rgb, #rrggbb or #rrggbbaa notation
+func ParseColor(value string) (color.Color, error) {
+	if !hexColorRegex.MatchString(value) {
+		return nil, fmt.Errorf("invalid color %q: expected #rgb, #rrggbb or #rrggbbaa", value)
+	}
+	hex := value[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	n, _ := strconv.ParseUint(hex, 16, 32)
+	return color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}, nil
+}
+
+// drawText draws the lines of a text overlay, with a shadow keeping it readable on any background
+func drawText(dst *image.NRGBA, text *Text, margin int) error {
+	f, err := overlayFont()
+	if err != nil {
+		return fmt.Errorf("failed to load overlay font: %w", err)
+	}
+	size := text.Size
+	if size <= 0 {
+		size = max(12, float64(dst.Bounds().Dy())/30)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return fmt.Errorf("failed to load overlay font: %w", err)
+	}
+	defer face.Close()
+
+	textColor := text.Color
+	if textColor == nil {
+		textColor = color.White
+	}
+	drawer := &font.Drawer{Dst: dst, Face: face}
+	lines := strings.Split(strings.TrimRight(text.Text, "\n"), "\n")
+	lineHeight := face.Metrics().Height.Ceil()
+	ascent := face.Metrics().Ascent.Ceil()
+	width := 0
+	for _, line := range lines {
+		width = max(width, drawer.MeasureString(line).Ceil())
+	}
+
+	at := place(dst.Bounds(), image.Pt(width, lineHeight*len(lines)), margin, text.Position)
+	shadow := max(1, int(size/16))
+	for i, line := range lines {
+		y := at.Y + ascent + i*lineHeight
+		drawer.Src = image.NewUniform(color.NRGBA{A: 160})
+		drawer.Dot = fixed.P(at.X+shadow, y+shadow)
+		drawer.DrawString(line)
+		drawer.Src = image.NewUniform(textColor)
+		drawer.Dot = fixed.P(at.X, y)
+		drawer.DrawString(line)
+	}
+	return nil
+}
+
+// place returns the top-left corner of an overlay of the given size at a position of a box
+func place(box image.Rectangle, size image.Point, margin int, position string) image.Point {
+	left, top := box.Min.X+margin, box.Min.Y+margin
+	right, bottom := box.Max.X-margin-size.X, box.Max.Y-margin-size.Y
+	switch position {
+	case "top-left":
+		return image.Pt(left, top)
+	case "top-right":
+		return image.Pt(right, top)
+	case "bottom-left":
+		return image.Pt(left, bottom)
+	case "center":
+		return image.Pt(box.Min.X+(box.Dx()-size.X)/2, box.Min.Y+(box.Dy()-size.Y)/2)
+	default:
+		return image.Pt(right, bottom)
+	}
+}
@@ -0,0 +1,232 @@
+// Package mqtt bridges rooms with an MQTT broker: images published to topics are published to rooms, and room
+// events are optionally published back to the broker
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/ncarlier/imgcast/internal/hook"
+	"github.com/ncarlier/imgcast/internal/imaging"
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+const (
+	// connectTimeout is the maximum duration of the first connection to the broker
+	connectTimeout = 10 * time.Second
+	// roomPlaceholder is replaced by the room name in the events topic
+	roomPlaceholder = "{room}"
+	// sharedPrefix starts the filters of shared subscriptions: $share/{group}/{filter}
+	sharedPrefix = "$share/"
+)
+
+var (
+	// errTooLarge is returned for messages exceeding the image size limit
+	errTooLarge = errors.New("image too large")
+	// errNotImage is returned for messages not holding an image
+	errNotImage = errors.New("not an image")
+)
+
+// Options configures the MQTT bridge
+type Options struct {
+	// Broker is the broker URL, such as tcp://localhost:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Topics maps the topic filters to subscribe to to the rooms their messages are published to
+	Topics map[string]string
+	// EventsTopic is the topic room events are published to, "{room}" being replaced by the room name (empty
+	// disables the publication of events)
+	EventsTopic string
+	QoS         byte
+	// MaxSize is the maximum size of the images received, in bytes (zero means no limit)
+	MaxSize int64
+}
+
+// Bridge is a connection to an MQTT broker publishing images to rooms and room events to the broker
+type Bridge struct {
+	manager *room.Manager
+	opts    Options
+	client  paho.Client
+}
+
+// ParseTopics parses a comma-separated list of topic=room mappings
+func ParseTopics(value string) (map[string]string, error) {
+	topics := make(map[string]string)
+	for _, mapping := range strings.Split(value, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+		topic, roomName, ok := strings.Cut(mapping, "=")
+		topic, roomName = strings.TrimSpace(topic), validator.NormalizeRoomPath(roomName)
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("invalid topic mapping %q: expected topic=room", mapping)
+		}
+		if err := validator.ValidateRoomPath(roomName); err != nil {
+			return nil, fmt.Errorf("invalid topic mapping %q: %w", mapping, err)
+		}
+		topics[topic] = roomName
+	}
+	return topics, nil
+}
+
+// New creates an MQTT bridge
+func New(manager *room.Manager, opts Options) *Bridge {
+	b := &Bridge{manager: manager, opts: opts}
+
+	clientOpts := paho.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetDefaultPublishHandler(b.receive).
+		SetOnConnectHandler(b.subscribe).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			slog.Warn("MQTT connection lost", "broker", opts.Broker, "error", err)
+		})
+	b.client = paho.NewClient(clientOpts)
+	return b
+}
+
+// Start connects to the broker, retrying in the background if it is unavailable, and publishes the room events
+// Subscriptions are renewed on every connection.
+func (b *Bridge) Start() error {
+	token := b.client.Connect()
+	if token.WaitTimeout(connectTimeout) && token.Error() != nil {
+		return token.Error()
+	}
+	if b.opts.EventsTopic != "" {
+		b.manager.OnEvent(b.publishEvent)
+	}
+	return nil
+}
+
+// subscribe subscribes to the topics mapped to rooms
+// Messages are handled by the default handler, so that a message matching several filters is handled once.
+func (b *Bridge) subscribe(client paho.Client) {
+	slog.Info("MQTT connected", "broker", b.opts.Broker)
+	for topic := range b.opts.Topics {
+		token := client.Subscribe(topic, b.opts.QoS, nil)
+		go func() {
+			if token.Wait(); token.Error() != nil {
+				slog.Error("Unable to subscribe to MQTT topic", "topic", topic, "error", token.Error())
+			}
+		}()
+	}
+}
+
+// receive publishes the image of a message to the rooms mapped to its topic
+// Messages not holding an image are ignored, so that events published to a subscribed topic are not looped back.
+func (b *Bridge) receive(_ paho.Client, msg paho.Message) {
+	payload := msg.Payload()
+	rooms := roomsForTopic(b.opts.Topics, msg.Topic())
+	if err := checkPayload(payload, b.opts.MaxSize); errors.Is(err, errNotImage) {
+		slog.Debug("MQTT message ignored: not an image", "topic", msg.Topic(), "rooms", rooms)
+		return
+	} else if err != nil {
+		slog.Warn("MQTT image too large", "topic", msg.Topic(), "rooms", rooms, "size", len(payload))
+		return
+	}
+	for _, roomName := range rooms {
+		b.publish(msg.Topic(), roomName, payload)
+	}
+}
+
+// publish publishes an image received on a topic to a room
+func (b *Bridge) publish(topic, roomName string, payload []byte) {
+	rm, err := b.manager.GetRoom(roomName)
+	if err != nil {
+		slog.Warn("Unable to publish MQTT image", "topic", topic, "room", roomName, "error", err)
+		return
+	}
+	published, err := b.manager.Publish(rm, "", bytes.NewReader(payload))
+	var rejected *hook.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		slog.Info("Image rejected", "topic", topic, "room", rm.Name, "hook", rejected.Hook, "reason", rejected.Reason)
+	case err != nil:
+		slog.Error("Unable to publish MQTT image", "topic", topic, "room", rm.Name, "error", err)
+	case published:
+		slog.Info("Image received by MQTT", "topic", topic, "room", rm.Name)
+	}
+}
+
+// publishEvent publishes a room event to the events topic, without waiting for the broker
+func (b *Bridge) publishEvent(event room.Event) {
+	topic, payload, err := eventMessage(b.opts.EventsTopic, event)
+	if err != nil {
+		slog.Error("Unable to encode MQTT event", "room", event.Room, "error", err)
+		return
+	}
+	b.client.Publish(topic, b.opts.QoS, false, payload)
+}
+
+// roomsForTopic returns the rooms mapped to the filters matching a topic, sorted and without duplicates
+func roomsForTopic(topics map[string]string, topic string) []string {
+	var rooms []string
+	for filter, roomName := range topics {
+		if matchTopic(filter, topic) {
+			rooms = append(rooms, roomName)
+		}
+	}
+	slices.Sort(rooms)
+	return slices.Compact(rooms)
+}
+
+// matchTopic reports whether a topic matches a topic filter, "+" matching a single level and a trailing "#" any
+// number of levels
+// Topics starting with "$" are not matched by wildcards at their first level.
+func matchTopic(filter, topic string) bool {
+	if strings.HasPrefix(filter, sharedPrefix) {
+		_, filter, _ = strings.Cut(filter[len(sharedPrefix):], "/")
+	}
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	filterLevels, topicLevels := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return i == len(filterLevels)-1
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// checkPayload checks that a message holds an image within the size limit (zero means no limit)
+func checkPayload(payload []byte, maxSize int64) error {
+	if maxSize > 0 && int64(len(payload)) > maxSize {
+		return errTooLarge
+	}
+	if imaging.DetectFormat(payload) == "" {
+		return errNotImage
+	}
+	return nil
+}
+
+// eventMessage returns the topic and the JSON payload of a room event, "{room}" being replaced by the room name
+// in the topic
+func eventMessage(topicPattern string, event room.Event) (string, []byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.ReplaceAll(topicPattern, roomPlaceholder, event.Room), payload, nil
+}
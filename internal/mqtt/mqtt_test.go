@@ -0,0 +1,151 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+func TestParseTopics(t *testing.T) {
+	topics, err := ParseTopics(" cameras/+/front = Team-Alpha ,cameras/gate=acme/lobby,, ")
+	if err != nil {
+		t.Fatalf("ParseTopics: %v", err)
+	}
+	want := map[string]string{"cameras/+/front": "team-alpha", "cameras/gate": "acme/lobby"}
+	if len(topics) != len(want) {
+		t.Fatalf("ParseTopics = %v, want %v", topics, want)
+	}
+	for topic, roomName := range want {
+		if topics[topic] != roomName {
+			t.Errorf("topic %s mapped to %q, want %q", topic, topics[topic], roomName)
+		}
+	}
+
+	for _, value := range []string{"cameras/gate", "=lobby", "cameras/gate=", "cameras/gate=bad room"} {
+		if _, err := ParseTopics(value); err == nil {
+			t.Errorf("ParseTopics(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"cameras/gate", "cameras/gate", true},
+		{"cameras/gate", "cameras/gate/2", false},
+		{"cameras/gate", "cameras", false},
+		{"cameras/+/front", "cameras/north/front", true},
+		{"cameras/+/front", "cameras/north/back", false},
+		{"cameras/+/front", "cameras/front", false},
+		{"cameras/+", "cameras/", true},
+		{"cameras/#", "cameras", true},
+		{"cameras/#", "cameras/north/front", true},
+		{"cameras/#", "doorbells/north", false},
+		{"#", "cameras/north", true},
+		{"+/+", "cameras/north", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"cameras/#/front", "cameras/north/front", false},
+		{"$share/imgcast/cameras/+", "cameras/north", true},
+		{"$share/imgcast/cameras/+", "doorbells/north", false},
+	}
+	for _, tt := range tests {
+		if got := matchTopic(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestRoomsForTopic(t *testing.T) {
+	topics := map[string]string{
+		"cameras/+/front": "team-alpha",
+		"cameras/#":       "archive",
+		"cameras/gate":    "team-beta",
+		"cameras/north/+": "team-alpha",
+	}
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"cameras/gate", []string{"archive", "team-beta"}},
+		{"cameras/north/front", []string{"archive", "team-alpha"}},
+		{"cameras/south/front", []string{"archive", "team-alpha"}},
+		{"cameras", []string{"archive"}},
+		{"doorbells/gate", nil},
+	}
+	for _, tt := range tests {
+		if got := roomsForTopic(topics, tt.topic); !slices.Equal(got, tt.want) {
+			t.Errorf("roomsForTopic(%q) = %v, want %v", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPayload(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	img := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		payload []byte
+		maxSize int64
+		want    error
+	}{
+		{"image", img, 0, nil},
+		{"image within limit", img, int64(len(img)), nil},
+		{"image too large", img, int64(len(img)) - 1, errTooLarge},
+		{"event", []byte(`{"type":"image.published"}`), 0, errNotImage},
+		{"empty", nil, 0, errNotImage},
+	}
+	for _, tt := range tests {
+		if err := checkPayload(tt.payload, tt.maxSize); !errors.Is(err, tt.want) {
+			t.Errorf("%s: checkPayload = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEventMessage(t *testing.T) {
+	event := room.Event{
+		ID:        "1",
+		Type:      room.EventImagePublished,
+		Room:      "acme/lobby",
+		Slot:      "left",
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"imgcast/{room}/events", "imgcast/acme/lobby/events"},
+		{"imgcast/events", "imgcast/events"},
+	}
+	for _, tt := range tests {
+		topic, payload, err := eventMessage(tt.pattern, event)
+		if err != nil {
+			t.Fatalf("eventMessage: %v", err)
+		}
+		if topic != tt.want {
+			t.Errorf("eventMessage(%q) topic = %q, want %q", tt.pattern, topic, tt.want)
+		}
+		var decoded room.Event
+		if err := json.Unmarshal(payload, &decoded); err != nil {
+			t.Fatalf("invalid payload %s: %v", payload, err)
+		}
+		if decoded != event {
+			t.Errorf("payload = %+v, want %+v", decoded, event)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package room
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+const (
+	// DefaultHistorySize is the number of images published under a file name kept in the publication history of
+	// rooms without explicit size
+	DefaultHistorySize = 10
+	// maxHistorySize is the maximum number of images kept in the publication history of a room
+	maxHistorySize = 100
+	// maxFileNameLength is the maximum length of the file name of a published image
+	maxFileNameLength = 255
+)
+
+var (
+	// ErrHistoryNotFound is returned when referencing an image missing from the publication history
+	ErrHistoryNotFound = errors.New("history entry not found")
+	// ErrInvalidFileName is returned when publishing an image under an invalid file name
+	ErrInvalidFileName = errors.New("invalid file name")
+)
+
+// HistoryEntry is an image published to a room, kept in its publication history
+type HistoryEntry struct {
+	ID string `json:"id"`
+	// Name is the file name the image was published under, or a name derived from its publication time
+	Name        string    `json:"name"`
+	Slot        string    `json:"slot,omitempty"`
+	Format      string    `json:"format,omitempty"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// History returns the publication history of a room, most recent first
+func (m *Manager) History(roomName string) ([]HistoryEntry, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	room.historyMu.Lock()
+	entries, err := m.loadHistory(room.Name)
+	room.historyMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// HistoryImage returns the entry of the publication history of a room with the given file name, along with
+// the path to its image
+func (m *Manager) HistoryImage(roomName, name string) (HistoryEntry, string, error) {
+	entries, err := m.History(roomName)
+	if err != nil {
+		return HistoryEntry{}, "", err
+	}
+	index := slices.IndexFunc(entries, func(e HistoryEntry) bool { return e.Name == name })
+	if index < 0 {
+		return HistoryEntry{}, "", ErrHistoryNotFound
+	}
+	roomName, _ = normalizeRoomName(roomName)
+	return entries[index], m.storage.GetHistoryImagePath(roomName, entries[index].ID), nil
+}
+
+// recordHistory adds an image published to a room slot to the room publication history, evicting the oldest
+// images beyond the given history size
+// An image published under the file name of a previous one replaces it.
+func (m *Manager) recordHistory(room *Room, slot, name string, publishedAt time.Time, data []byte, size int) error {
+	hash := sha256.Sum256(data)
+	entry := HistoryEntry{
+		ID:          newItemID(),
+		Name:        name,
+		Slot:        slot,
+		Format:      imaging.DetectFormat(data),
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(hash[:]),
+		PublishedAt: publishedAt,
+	}
+	if err := m.storage.SaveHistoryImage(room.Name, entry.ID, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if entry.Name == "" {
+		entry.Name = historyName(entry)
+	}
+
+	room.historyMu.Lock()
+	defer room.historyMu.Unlock()
+
+	entries, err := m.loadHistory(room.Name)
+	if err != nil {
+		m.storage.DeleteHistoryImage(room.Name, entry.ID)
+		return err
+	}
+	var evicted []HistoryEntry
+	entries = slices.DeleteFunc(entries, func(e HistoryEntry) bool {
+		if e.Name == entry.Name {
+			evicted = append(evicted, e)
+			return true
+		}
+		return false
+	})
+	entries = append(entries, entry)
+	if len(entries) > size {
+		evicted = append(evicted, entries[:len(entries)-size]...)
+		entries = entries[len(entries)-size:]
+	}
+	if err := saveJSON(m.storage.GetRoomHistoryPath(room.Name), entries); err != nil {
+		m.storage.DeleteHistoryImage(room.Name, entry.ID)
+		return err
+	}
+
+	for _, e := range evicted {
+		if err := m.storage.DeleteHistoryImage(room.Name, e.ID); err != nil {
+			slog.Warn("Unable to delete history image", "room", room.Name, "entry", e.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// loadHistory reads the publication history of a room, oldest first
+// The room history lock must be held.
+func (m *Manager) loadHistory(roomName string) ([]HistoryEntry, error) {
+	entries := []HistoryEntry{}
+	err := loadJSON(m.storage.GetRoomHistoryPath(roomName), &entries)
+	return entries, err
+}
+
+// historySize returns the number of images kept in the publication history of a room when publishing an image
+// under a file name or not, zero meaning that the image is not recorded
+// Without explicit size, only the images published under a file name (written to the WebDAV folder) are recorded.
+func historySize(settings Settings, named bool) int {
+	if settings.HistorySize == 0 && named {
+		return DefaultHistorySize
+	}
+	return settings.HistorySize
+}
+
+// historyName returns the file name of an image published without name, from its slot and publication time
+func historyName(entry HistoryEntry) string {
+	name := entry.PublishedAt.Format("20060102-150405") + "-" + entry.ID[:6]
+	if entry.Slot != "" {
+		name = entry.Slot + "-" + name
+	}
+	switch entry.Format {
+	case "":
+		return name
+	case "jpeg":
+		return name + ".jpg"
+	default:
+		return name + "." + entry.Format
+	}
+}
+
+// validateFileName checks that a file name can be listed in the publication history
+func validateFileName(name string) error {
+	if name == "" || name == "." || name == ".." || len(name) > maxFileNameLength ||
+		strings.ContainsAny(name, `/\`) || strings.ContainsFunc(name, unicode.IsControl) {
+		return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+	return nil
+}
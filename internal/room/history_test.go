@@ -0,0 +1,151 @@
+package room
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image/color"
+	"os"
+	"sync"
+	"testing"
+)
+
+// historyNames returns the file names of the publication history of a room, most recent first
+func historyNames(t *testing.T, m *Manager, roomName string) []string {
+	t.Helper()
+	entries, err := m.History(roomName)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func TestHistoryDisabledByDefault(t *testing.T) {
+	m := newTestManager(t)
+	room := newTestRoom(t, m, "lobby", Settings{})
+
+	if _, err := m.Publish(room, "", bytes.NewReader(testPNG(t, color.White))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if names := historyNames(t, m, "lobby"); len(names) != 0 {
+		t.Errorf("history = %v, want no image published without name", names)
+	}
+	if _, err := os.Stat(m.storage.GetRoomHistoryPath("lobby")); !os.IsNotExist(err) {
+		t.Errorf("history file written for a room without history: %v", err)
+	}
+
+	// Images written to the WebDAV folder are listed
+	if _, err := m.PublishFile(room, "", "photo.png", bytes.NewReader(testPNG(t, color.Black))); err != nil {
+		t.Fatalf("PublishFile: %v", err)
+	}
+	if names := historyNames(t, m, "lobby"); len(names) != 1 || names[0] != "photo.png" {
+		t.Errorf("history = %v, want [photo.png]", names)
+	}
+}
+
+func TestHistorySize(t *testing.T) {
+	m := newTestManager(t)
+	room := newTestRoom(t, m, "lobby", Settings{HistorySize: 2})
+
+	colors := []color.Color{color.White, color.Black, color.Gray{Y: 128}}
+	for _, c := range colors {
+		if _, err := m.Publish(room, "", bytes.NewReader(testPNG(t, c))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	entries, err := m.History("lobby")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("history holds %d images, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(m.storage.GetHistoryImagePath("lobby", entry.ID)); err != nil {
+			t.Errorf("history image %s: %v", entry.Name, err)
+		}
+	}
+
+	// An image published under the name of a previous one replaces it
+	for range 2 {
+		if _, err := m.PublishFile(room, "", "photo.png", bytes.NewReader(testPNG(t, color.White))); err != nil {
+			t.Fatalf("PublishFile: %v", err)
+		}
+	}
+	if names := historyNames(t, m, "lobby"); len(names) != 2 || names[0] != "photo.png" || names[1] == "photo.png" {
+		t.Errorf("history = %v, want photo.png and the last image published without name", names)
+	}
+}
+
+func TestHistoryConcurrentPublications(t *testing.T) {
+	m := newTestManager(t)
+	room := newTestRoom(t, m, "lobby", Settings{HistorySize: 20})
+
+	images := make(map[string][]byte)
+	for i := range 8 {
+		images[fmt.Sprintf("photo%d.png", i)] = testPNG(t, color.Gray{Y: uint8(i * 30)})
+	}
+	var wg sync.WaitGroup
+	for name, data := range images {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.PublishFile(room, "", name, bytes.NewReader(data)); err != nil {
+				t.Errorf("PublishFile %s: %v", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := m.History("lobby")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(entries) != len(images) {
+		t.Fatalf("history holds %d images, want %d", len(entries), len(images))
+	}
+	for _, entry := range entries {
+		stored, err := os.ReadFile(m.storage.GetHistoryImagePath("lobby", entry.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stored, images[entry.Name]) {
+			t.Errorf("history image %s holds another image", entry.Name)
+		}
+		if hash := sha256.Sum256(stored); entry.SHA256 != hex.EncodeToString(hash[:]) || entry.Size != int64(len(stored)) {
+			t.Errorf("history entry %s does not describe the stored image", entry.Name)
+		}
+	}
+}
+
+func TestHistoryRecordsPublishedImage(t *testing.T) {
+	m := newTestManager(t)
+	room := newTestRoom(t, m, "lobby", Settings{HistorySize: 5, OverlayText: "{{ .Room }}"})
+
+	if _, err := m.PublishFile(room, "", "photo.png", bytes.NewReader(testPNG(t, color.White))); err != nil {
+		t.Fatalf("PublishFile: %v", err)
+	}
+	entry, path, err := m.HistoryImage("lobby", "photo.png")
+	if err != nil {
+		t.Fatalf("HistoryImage: %v", err)
+	}
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := os.ReadFile(m.storage.GetRoomImagePath("lobby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, live) {
+		t.Error("history image differs from the published image")
+	}
+	if hash := sha256.Sum256(stored); entry.SHA256 != hex.EncodeToString(hash[:]) {
+		t.Errorf("history hash %s is not the hash of the stored image", entry.SHA256)
+	}
+}
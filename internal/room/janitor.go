@@ -0,0 +1,111 @@
+package room
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// GCPolicy defines when abandoned rooms are garbage collected
+type GCPolicy struct {
+	// MaxIdle is the duration after which a room without update is deleted (zero disables the rule)
+	MaxIdle time.Duration
+	// Interval is the delay between two janitor runs
+	Interval time.Duration
+	// DryRun only reports the rooms to delete without deleting them
+	DryRun bool
+}
+
+// Expiration describes a room eligible for garbage collection
+type Expiration struct {
+	Room       string    `json:"room"`
+	Reason     string    `json:"reason"`
+	LastUpdate time.Time `json:"last_update"`
+}
+
+// Sweep deletes expired rooms and rooms idle for longer than maxIdle
+// In dry-run mode, rooms are only reported. The returned list contains the expired rooms.
+func (m *Manager) Sweep(maxIdle time.Duration, dryRun bool) ([]Expiration, error) {
+	roomNames, err := m.storage.ListRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var expired []Expiration
+	for _, roomName := range roomNames {
+		expiration, err := m.checkExpiration(roomName, now, maxIdle)
+		if err != nil {
+			slog.Warn("Unable to check room expiration", "room", roomName, "error", err)
+			continue
+		}
+		if expiration == nil {
+			continue
+		}
+
+		if !dryRun {
+			if err := m.DeleteRoom(roomName); err != nil {
+				slog.Error("Failed to delete expired room", "room", roomName, "error", err)
+				continue
+			}
+		}
+		expired = append(expired, *expiration)
+	}
+
+	return expired, nil
+}
+
+// checkExpiration returns the expiration of a room, or nil if the room is still alive
+func (m *Manager) checkExpiration(roomName string, now time.Time, maxIdle time.Duration) (*Expiration, error) {
+	settings, err := loadSettings(m.storage.GetRoomSettingsPath(roomName))
+	if err != nil {
+		return nil, err
+	}
+
+	lastUpdate, err := m.storage.LastUpdate(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.ExpiresAt != nil && now.After(*settings.ExpiresAt) {
+		return &Expiration{
+			Room:       roomName,
+			Reason:     fmt.Sprintf("expired at %s", settings.ExpiresAt.Format(time.RFC3339)),
+			LastUpdate: lastUpdate,
+		}, nil
+	}
+
+	if maxIdle > 0 && now.Sub(lastUpdate) > maxIdle {
+		return &Expiration{
+			Room:       roomName,
+			Reason:     fmt.Sprintf("not updated for %s", now.Sub(lastUpdate).Truncate(time.Minute)),
+			LastUpdate: lastUpdate,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// StartJanitor runs the garbage collection of rooms in background according to the policy
+func (m *Manager) StartJanitor(policy GCPolicy) {
+	if policy.Interval <= 0 {
+		slog.Info("Room janitor disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+		for {
+			expired, err := m.Sweep(policy.MaxIdle, policy.DryRun)
+			if err != nil {
+				slog.Error("Room janitor failed", "error", err)
+			}
+			for _, expiration := range expired {
+				slog.Info("Room garbage collected", "room", expiration.Room, "reason", expiration.Reason, "dryRun", policy.DryRun)
+			}
+			<-ticker.C
+		}
+	}()
+	slog.Info("Room janitor started", "interval", policy.Interval, "maxIdle", policy.MaxIdle, "dryRun", policy.DryRun)
+}
@@ -0,0 +1,41 @@
+package room
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+// ErrNoMetadataReport is returned when the image of a room slot was published without metadata processing
+var ErrNoMetadataReport = errors.New("no metadata report")
+
+// MetadataReport returns the report of the metadata processing applied to the image of a room slot
+func (m *Manager) MetadataReport(roomName, slot string) (imaging.MetadataReport, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return imaging.MetadataReport{}, err
+	}
+
+	var report *imaging.MetadataReport
+	if err := loadJSON(m.storage.GetMetadataReportPath(room.Name, slot), &report); err != nil {
+		return imaging.MetadataReport{}, err
+	}
+	if report == nil {
+		return imaging.MetadataReport{}, ErrNoMetadataReport
+	}
+	return *report, nil
+}
+
+// saveMetadataReport records the metadata processing applied to the image of a room slot
+// A nil report removes the report of a previous image.
+func (m *Manager) saveMetadataReport(room *Room, slot string, report *imaging.MetadataReport) error {
+	if report == nil {
+		return m.storage.DeleteMetadataReport(room.Name, slot)
+	}
+	if len(report.Removed) > 0 || report.Orientation != 0 {
+		slog.Info("Image metadata processed", "room", room.Name, "slot", slot,
+			"removed", report.Removed, "gps", report.GPS, "orientation", report.Orientation)
+	}
+	return saveJSON(m.storage.GetMetadataReportPath(room.Name, slot), report)
+}
@@ -0,0 +1,145 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/ncarlier/imgcast/internal/auth"
+)
+
+var (
+	// ErrNamespaceNotFound is returned when a namespace does not exist
+	ErrNamespaceNotFound = errors.New("namespace does not exist")
+	// ErrNamespaceNotEmpty is returned when deleting a namespace still containing rooms
+	ErrNamespaceNotEmpty = errors.New("namespace is not empty")
+)
+
+// NamespaceOf returns the namespace of a room or namespace path ("" for top-level ones)
+func NamespaceOf(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+// ancestorNamespaces returns the namespaces of a path, from the nearest to the top-level one
+func ancestorNamespaces(path string) []string {
+	var namespaces []string
+	for namespace := NamespaceOf(path); namespace != ""; namespace = NamespaceOf(namespace) {
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+// checkParentNamespace ensures that the namespace of a nested path exists
+func (m *Manager) checkParentNamespace(path string) error {
+	if parent := NamespaceOf(path); parent != "" && !m.storage.NamespaceExists(parent) {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, parent)
+	}
+	return nil
+}
+
+// AuthenticateNamespaceAdmin authenticates a user allowed to administer a namespace
+// Global admins administer all namespaces, namespace admins administer their namespace and its descendants.
+// An empty namespace designates the top level, administered by global admins only.
+func (m *Manager) AuthenticateNamespaceAdmin(namespace, username, password string) error {
+	if err := m.AuthenticateAdmin(username, password); err == nil || !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+
+	if namespace != "" {
+		for _, ns := range append([]string{namespace}, ancestorNamespaces(namespace)...) {
+			authenticated, err := auth.NewAuthenticator(m.storage.GetNamespaceHtpasswdPath(ns)).Authenticate(username, password)
+			if err != nil {
+				return fmt.Errorf("authentication error: %w", err)
+			}
+			if authenticated {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("namespace admin authentication failed: %w", ErrUnauthorized)
+}
+
+// CreateNamespace creates a namespace on behalf of an admin of its parent namespace
+// The creator is registered as the first namespace admin, followed by the given admins
+func (m *Manager) CreateNamespace(namespace, username, password string, admins []Member) error {
+	namespace, err := normalizeRoomName(namespace)
+	if err != nil {
+		return err
+	}
+
+	if err := m.AuthenticateNamespaceAdmin(NamespaceOf(namespace), username, password); err != nil {
+		return err
+	}
+
+	admins = append([]Member{{Username: username, Password: password}}, admins...)
+	if err := m.ProvisionNamespace(namespace, admins); err != nil {
+		return err
+	}
+
+	slog.Info("Namespace created", "namespace", namespace, "creator", username)
+	return nil
+}
+
+// ProvisionNamespace creates a namespace without admin authentication
+// It is intended for local administration tasks having direct access to the storage
+func (m *Manager) ProvisionNamespace(namespace string, admins []Member) error {
+	namespace, err := normalizeRoomName(namespace)
+	if err != nil {
+		return err
+	}
+
+	if m.storage.NamespaceExists(namespace) || m.storage.RoomExists(namespace) {
+		return ErrRoomExists
+	}
+	if err := m.checkParentNamespace(namespace); err != nil {
+		return err
+	}
+
+	if err := m.storage.CreateNamespace(namespace); err != nil {
+		return err
+	}
+
+	namespaceAuth := auth.NewAuthenticator(m.storage.GetNamespaceHtpasswdPath(namespace))
+	if err := namespaceAuth.Create(); err != nil {
+		return fmt.Errorf("failed to create namespace authentication: %w", err)
+	}
+	for _, admin := range admins {
+		if err := namespaceAuth.SetUser(admin.Username, admin.Password); err != nil {
+			return fmt.Errorf("failed to add namespace admin: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteNamespace deletes a namespace that no longer contains rooms
+func (m *Manager) DeleteNamespace(namespace string) error {
+	namespace, err := normalizeRoomName(namespace)
+	if err != nil {
+		return err
+	}
+
+	if !m.storage.NamespaceExists(namespace) {
+		return ErrNamespaceNotFound
+	}
+
+	rooms, err := m.storage.ListRoomsIn(namespace)
+	if err != nil {
+		return err
+	}
+	if len(rooms) > 0 {
+		return fmt.Errorf("%w: %d room(s) left", ErrNamespaceNotEmpty, len(rooms))
+	}
+
+	if err := m.storage.DeleteNamespace(namespace); err != nil {
+		return err
+	}
+
+	slog.Info("Namespace deleted", "namespace", namespace)
+	return nil
+}
@@ -0,0 +1,173 @@
+package room
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+// MaxWatermarkSize is the maximum size of a watermark image, in bytes
+const MaxWatermarkSize = 4 << 20
+
+// ErrWatermarkTooLarge is returned when setting a watermark exceeding the size or dimension limits
+var ErrWatermarkTooLarge = errors.New("watermark too large")
+
+// overlayData holds the fields available to the text overlay template
+type overlayData struct {
+	Room  string
+	Slot  string
+	Title string
+	// Time is the publication time of the image
+	Time time.Time
+}
+
+// SetWatermark sets the image stamped onto the images published to a room
+func (m *Manager) SetWatermark(roomName string, reader io.Reader) error {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, MaxWatermarkSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read watermark: %w", err)
+	}
+	if len(data) > MaxWatermarkSize {
+		return fmt.Errorf("%w: at most %d bytes", ErrWatermarkTooLarge, MaxWatermarkSize)
+	}
+	// The dimensions are checked before decoding the whole image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %w", imaging.ErrUnsupportedImage, err)
+	}
+	if config.Width > imaging.MaxDimension || config.Height > imaging.MaxDimension {
+		return fmt.Errorf("%w: at most %dx%d pixels", ErrWatermarkTooLarge, imaging.MaxDimension, imaging.MaxDimension)
+	}
+	if _, err := imaging.Decode(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := m.storage.SaveWatermark(room.Name, bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	slog.Info("Room watermark set", "room", room.Name)
+	return nil
+}
+
+// DeleteWatermark removes the watermark of a room
+func (m *Manager) DeleteWatermark(roomName string) error {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return err
+	}
+
+	if err := m.storage.DeleteWatermark(room.Name); err != nil {
+		return err
+	}
+
+	slog.Info("Room watermark deleted", "room", room.Name)
+	return nil
+}
+
+// OriginalImagePath returns the path to the image of a room slot as uploaded, before its overlays were applied
+func (m *Manager) OriginalImagePath(room *Room, slot string) string {
+	path := m.storage.GetOriginalImagePath(room.Name, slot)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return m.storage.GetSlotImagePath(room.Name, slot)
+}
+
+// hasOverlay reports whether overlays are stamped onto the images published to a room
+func (m *Manager) hasOverlay(room *Room, settings Settings) bool {
+	return settings.OverlayText != "" || m.storage.HasWatermark(room.Name)
+}
+
+// applyOverlay stamps the room watermark and text overlay onto an image
+// JPEG images are kept in JPEG, other formats are converted to PNG.
+func (m *Manager) applyOverlay(room *Room, slot string, settings Settings, data []byte) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var watermark *imaging.Watermark
+	if m.storage.HasWatermark(room.Name) {
+		mark, err := m.loadWatermark(room)
+		if err != nil {
+			return nil, err
+		}
+		opacity := settings.WatermarkOpacity
+		if opacity == 0 {
+			opacity = 1
+		}
+		watermark = &imaging.Watermark{
+			Image:    mark,
+			Position: settings.WatermarkPosition,
+			Opacity:  opacity,
+			Scale:    settings.WatermarkScale,
+		}
+	}
+
+	var text *imaging.Text
+	if settings.OverlayText != "" {
+		data := overlayData{Room: room.Name, Slot: slot, Title: settings.Title, Time: time.Now()}
+		content, err := renderOverlayText(settings.OverlayText, data)
+		if err != nil {
+			return nil, err
+		}
+		text = &imaging.Text{Text: content, Position: settings.OverlayTextPosition, Size: settings.OverlayTextSize}
+		if settings.OverlayTextColor != "" {
+			text.Color, _ = imaging.ParseColor(settings.OverlayTextColor)
+		}
+	}
+
+	composed, err := imaging.Composite(img, watermark, text)
+	if err != nil {
+		return nil, err
+	}
+
+	format := "png"
+	if imaging.DetectFormat(data) == "jpeg" {
+		format = "jpeg"
+	}
+	var out bytes.Buffer
+	if err := imaging.Encode(&out, composed, format); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// loadWatermark decodes the watermark image of a room
+func (m *Manager) loadWatermark(room *Room) (image.Image, error) {
+	file, err := os.Open(m.storage.GetWatermarkPath(room.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open watermark: %w", err)
+	}
+	defer file.Close()
+
+	return imaging.Decode(file)
+}
+
+// renderOverlayText renders the template of a text overlay
+// The template fields are .Room, .Slot, .Title and .Time ({{ .Time.Format "15:04" }}).
+func renderOverlayText(text string, data overlayData) (string, error) {
+	tmpl, err := template.New("overlay").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var content strings.Builder
+	if err := tmpl.Execute(&content, data); err != nil {
+		return "", err
+	}
+	return content.String(), nil
+}
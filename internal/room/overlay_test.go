@@ -0,0 +1,63 @@
+package room
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+func TestOverlayFailureRefusesImage(t *testing.T) {
+	m := newTestManager(t)
+	room := newTestRoom(t, m, "lobby", Settings{OverlayText: "{{ .Room }}"})
+
+	// A PNG signature followed by garbage cannot be stamped
+	corrupt := append(testPNG(t, color.White)[:16], make([]byte, 64)...)
+	_, err := m.Publish(room, "", bytes.NewReader(corrupt))
+	if !errors.Is(err, imaging.ErrUnsupportedImage) {
+		t.Fatalf("Publish = %v, want ErrUnsupportedImage", err)
+	}
+	if m.storage.HasImage("lobby", "") {
+		t.Error("image published without its overlays")
+	}
+
+	if _, err := m.Publish(room, "", bytes.NewReader(testPNG(t, color.White))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if !m.storage.HasImage("lobby", "") {
+		t.Error("image not published")
+	}
+}
+
+func TestSetWatermarkLimits(t *testing.T) {
+	m := newTestManager(t)
+	newTestRoom(t, m, "lobby", Settings{})
+
+	encode := func(width, height int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"valid", encode(64, 32), nil},
+		{"too wide", encode(imaging.MaxDimension+1, 1), ErrWatermarkTooLarge},
+		{"too heavy", append(encode(1, 1), make([]byte, MaxWatermarkSize)...), ErrWatermarkTooLarge},
+		{"not an image", []byte("logo"), imaging.ErrUnsupportedImage},
+	}
+	for _, tt := range tests {
+		if err := m.SetWatermark("lobby", bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: SetWatermark = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
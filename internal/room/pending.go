@@ -0,0 +1,234 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// maxPendingPublications is the maximum number of scheduled publications of a room
+const maxPendingPublications = 100
+
+var (
+	// ErrPublicationNotFound is returned when referencing an unknown scheduled publication
+	ErrPublicationNotFound = errors.New("scheduled publication not found")
+	// ErrPendingFull is returned when scheduling a publication in a room having too many of them
+	ErrPendingFull = errors.New("too many scheduled publications")
+	// ErrInvalidPublishTime is returned when scheduling a publication in the past
+	ErrInvalidPublishTime = errors.New("publication time must be in the future")
+)
+
+// Publication is an uploaded image waiting to be published at a given time
+type Publication struct {
+	ID        string    `json:"id"`
+	Slot      string    `json:"slot,omitempty"`
+	PublishAt time.Time `json:"publish_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// pendingState holds the scheduled publications of a loaded room and their timers
+type pendingState struct {
+	publications []Publication
+	timers       map[string]*time.Timer
+	closed       bool
+}
+
+// SchedulePublication stores an image to be published to a room slot at the given time
+// The image is passed through the pre-publish hooks right away, so that a veto is returned to the uploader
+// as a *hook.RejectedError, and the image they return is stored.
+func (m *Manager) SchedulePublication(room *Room, slot string, publishAt time.Time, createdBy string, reader io.Reader) (Publication, error) {
+	now := time.Now()
+	if !publishAt.After(now) {
+		return Publication{}, ErrInvalidPublishTime
+	}
+	reader, err := m.applyHooks(room, slot, reader)
+	if err != nil {
+		return Publication{}, err
+	}
+
+	publication := Publication{
+		ID:        newItemID(),
+		Slot:      slot,
+		PublishAt: publishAt.UTC(),
+		CreatedBy: createdBy,
+		CreatedAt: now.UTC(),
+	}
+
+	room.pendingMu.Lock()
+	defer room.pendingMu.Unlock()
+
+	if len(room.pending.publications) >= maxPendingPublications {
+		return Publication{}, fmt.Errorf("%w: at most %d", ErrPendingFull, maxPendingPublications)
+	}
+
+	if err := m.storage.SavePendingImage(room.Name, publication.ID, reader); err != nil {
+		return Publication{}, err
+	}
+
+	room.pending.publications = append(room.pending.publications, publication)
+	if err := m.savePending(room); err != nil {
+		return Publication{}, err
+	}
+	m.schedulePublication(room, publication)
+
+	slog.Info("Publication scheduled", "room", room.Name, "slot", slot, "publication", publication.ID, "at", publication.PublishAt)
+	return publication, nil
+}
+
+// ListPublications returns the scheduled publications of a room, by publication time
+func (m *Manager) ListPublications(roomName string) ([]Publication, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	room.pendingMu.Lock()
+	defer room.pendingMu.Unlock()
+
+	publications := append([]Publication{}, room.pending.publications...)
+	slices.SortStableFunc(publications, func(a, b Publication) int {
+		return a.PublishAt.Compare(b.PublishAt)
+	})
+	return publications, nil
+}
+
+// CancelPublication removes a scheduled publication of a room
+func (m *Manager) CancelPublication(roomName, id string) error {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return err
+	}
+
+	room.pendingMu.Lock()
+	defer room.pendingMu.Unlock()
+
+	if _, ok := m.removePublication(room, id); !ok {
+		return ErrPublicationNotFound
+	}
+	if err := m.storage.DeletePendingImage(room.Name, id); err != nil {
+		slog.Warn("Unable to delete pending image", "room", room.Name, "publication", id, "error", err)
+	}
+	if err := m.savePending(room); err != nil {
+		return err
+	}
+
+	slog.Info("Publication cancelled", "room", room.Name, "publication", id)
+	return nil
+}
+
+// ResumePublications loads the rooms having scheduled publications so that they are published on time
+// Publications whose time passed while the server was stopped are published immediately.
+func (m *Manager) ResumePublications() {
+	roomNames, err := m.storage.ListRooms()
+	if err != nil {
+		slog.Error("Unable to resume scheduled publications", "error", err)
+		return
+	}
+
+	for _, roomName := range roomNames {
+		var publications []Publication
+		if err := loadJSON(m.storage.GetRoomPendingPath(roomName), &publications); err != nil || len(publications) == 0 {
+			continue
+		}
+		if _, err := m.GetRoom(roomName); err != nil {
+			slog.Error("Unable to resume scheduled publications", "room", roomName, "error", err)
+			continue
+		}
+		slog.Info("Scheduled publications resumed", "room", roomName, "count", len(publications))
+	}
+}
+
+// schedulePublication arms the timer of a scheduled publication
+// The pending lock must be held.
+func (m *Manager) schedulePublication(room *Room, publication Publication) {
+	state := &room.pending
+	if state.timers == nil {
+		state.timers = make(map[string]*time.Timer)
+	}
+	state.timers[publication.ID] = time.AfterFunc(time.Until(publication.PublishAt), func() {
+		room.pendingMu.Lock()
+		defer room.pendingMu.Unlock()
+		if !state.closed && !m.roomDeleted(room) {
+			m.firePublication(room, publication.ID)
+		}
+	})
+}
+
+// firePublication publishes a scheduled publication and removes it from the pending list
+// The pending lock must be held.
+func (m *Manager) firePublication(room *Room, id string) {
+	publication, ok := m.removePublication(room, id)
+	if !ok {
+		return
+	}
+
+	if err := m.publishPending(room, publication); err != nil {
+		slog.Error("Unable to publish scheduled image", "room", room.Name, "publication", id, "error", err)
+	} else {
+		slog.Info("Scheduled image published", "room", room.Name, "slot", publication.Slot, "publication", id)
+	}
+
+	if err := m.storage.DeletePendingImage(room.Name, id); err != nil {
+		slog.Warn("Unable to delete pending image", "room", room.Name, "publication", id, "error", err)
+	}
+	if err := m.savePending(room); err != nil {
+		slog.Error("Unable to save scheduled publications", "room", room.Name, "error", err)
+	}
+}
+
+// removePublication removes a publication from the pending list and stops its timer
+// The pending lock must be held.
+func (m *Manager) removePublication(room *Room, id string) (Publication, bool) {
+	state := &room.pending
+	index := slices.IndexFunc(state.publications, func(p Publication) bool { return p.ID == id })
+	if index < 0 {
+		return Publication{}, false
+	}
+
+	publication := state.publications[index]
+	state.publications = slices.Delete(state.publications, index, index+1)
+	if timer, ok := state.timers[id]; ok {
+		timer.Stop()
+		delete(state.timers, id)
+	}
+	return publication, true
+}
+
+// publishPending publishes the image of a scheduled publication
+func (m *Manager) publishPending(room *Room, publication Publication) error {
+	file, err := os.Open(m.storage.GetPendingImagePath(room.Name, publication.ID))
+	if err != nil {
+		return fmt.Errorf("failed to open pending image: %w", err)
+	}
+	defer file.Close()
+
+	_, err = m.publish(room, publication.Slot, "", file, true)
+	return err
+}
+
+// savePending persists the scheduled publications of a room
+// The pending lock must be held.
+func (m *Manager) savePending(room *Room) error {
+	publications := room.pending.publications
+	if publications == nil {
+		publications = []Publication{}
+	}
+	return saveJSON(m.storage.GetRoomPendingPath(room.Name), publications)
+}
+
+// closePending stops the scheduled publication timers of an unloaded room
+func (room *Room) closePending() {
+	room.pendingMu.Lock()
+	defer room.pendingMu.Unlock()
+
+	room.pending.closed = true
+	for id, timer := range room.pending.timers {
+		timer.Stop()
+		delete(room.pending.timers, id)
+	}
+}
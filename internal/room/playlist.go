@@ -0,0 +1,370 @@
+package room
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultPlaylistItemDuration is the display duration of a playlist item without explicit duration
+	DefaultPlaylistItemDuration = 10 * time.Second
+	// maxPlaylistItems is the maximum number of items of a playlist
+	maxPlaylistItems = 100
+)
+
+var (
+	// ErrPlaylistEmpty is returned when starting or skipping an empty playlist
+	ErrPlaylistEmpty = errors.New("playlist is empty")
+	// ErrPlaylistItemNotFound is returned when referencing an unknown playlist item
+	ErrPlaylistItemNotFound = errors.New("playlist item not found")
+	// ErrPlaylistFull is returned when adding an item to a full playlist
+	ErrPlaylistFull = errors.New("playlist is full")
+)
+
+// PlaylistItem is an image of a room playlist
+type PlaylistItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Duration is the display duration of the item, in seconds
+	Duration int `json:"duration"`
+}
+
+// Playlist is an ordered list of images displayed in turn as the main room image
+type Playlist struct {
+	Items   []PlaylistItem `json:"items"`
+	Playing bool           `json:"playing"`
+	Current int            `json:"current"`
+}
+
+// playlistState holds the playlist of a loaded room and its scheduling state
+// Items are published once the playlist lock is released: shown counts the items shown, and published the
+// items published, so that only the last item shown is published.
+type playlistState struct {
+	playlist  Playlist
+	timer     *time.Timer
+	closed    bool
+	shown     uint64
+	published uint64
+}
+
+// GetPlaylist returns the playlist of a room
+func (m *Manager) GetPlaylist(roomName string) (Playlist, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	room.playlistMu.Lock()
+	defer room.playlistMu.Unlock()
+	return room.playlist.playlist.clone(), nil
+}
+
+// AddPlaylistItem appends an image to the playlist of a room
+// The image is passed through the pre-publish hooks once, when added: a veto is returned as a *hook.RejectedError,
+// and the image they return is stored and displayed at each rotation.
+func (m *Manager) AddPlaylistItem(roomName, name string, duration time.Duration, reader io.Reader) (PlaylistItem, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return PlaylistItem{}, err
+	}
+	if reader, err = m.applyHooks(room, "", reader); err != nil {
+		return PlaylistItem{}, err
+	}
+
+	if duration <= 0 {
+		duration = DefaultPlaylistItemDuration
+	}
+	item := PlaylistItem{
+		ID:       newItemID(),
+		Name:     name,
+		Duration: max(1, int(duration.Seconds())),
+	}
+
+	room.playlistMu.Lock()
+	defer room.playlistMu.Unlock()
+
+	if len(room.playlist.playlist.Items) >= maxPlaylistItems {
+		return PlaylistItem{}, fmt.Errorf("%w: at most %d items", ErrPlaylistFull, maxPlaylistItems)
+	}
+
+	if err := m.storage.SavePlaylistItem(room.Name, item.ID, reader); err != nil {
+		return PlaylistItem{}, err
+	}
+
+	room.playlist.playlist.Items = append(room.playlist.playlist.Items, item)
+	if err := m.savePlaylist(room); err != nil {
+		return PlaylistItem{}, err
+	}
+
+	slog.Info("Playlist item added", "room", room.Name, "item", item.ID)
+	return item, nil
+}
+
+// UpdatePlaylist reorders the playlist items, updates their name and duration, and removes the items not listed
+func (m *Manager) UpdatePlaylist(roomName string, items []PlaylistItem) (Playlist, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	room.playlistMu.Lock()
+	defer m.publishPlaylist(room)
+	defer room.playlistMu.Unlock()
+
+	state := &room.playlist
+	existing := make(map[string]PlaylistItem, len(state.playlist.Items))
+	for _, item := range state.playlist.Items {
+		existing[item.ID] = item
+	}
+
+	updated := make([]PlaylistItem, 0, len(items))
+	for _, item := range items {
+		current, ok := existing[item.ID]
+		if !ok {
+			return Playlist{}, fmt.Errorf("%w: %s", ErrPlaylistItemNotFound, item.ID)
+		}
+		if item.Duration <= 0 {
+			item.Duration = current.Duration
+		}
+		updated = append(updated, item)
+		delete(existing, item.ID)
+	}
+
+	// Keep the current item if it is still in the playlist
+	currentID := ""
+	if state.playlist.Current < len(state.playlist.Items) {
+		currentID = state.playlist.Items[state.playlist.Current].ID
+	}
+	state.playlist.Items = updated
+	state.playlist.Current = 0
+	restart := state.playlist.Playing
+	for i, item := range updated {
+		if item.ID == currentID {
+			state.playlist.Current = i
+			restart = false
+		}
+	}
+
+	if len(updated) == 0 {
+		m.stopPlaylist(room)
+	} else if restart {
+		m.showPlaylistItem(room)
+	}
+
+	if err := m.savePlaylist(room); err != nil {
+		return Playlist{}, err
+	}
+
+	// Remove the images of the items no longer listed, once the playlist no longer references them
+	for id := range existing {
+		if err := m.storage.DeletePlaylistItem(room.Name, id); err != nil {
+			slog.Warn("Unable to delete playlist item", "room", room.Name, "item", id, "error", err)
+		}
+	}
+
+	slog.Info("Playlist updated", "room", room.Name, "items", len(updated))
+	return state.playlist.clone(), nil
+}
+
+// StartPlaylist starts displaying the playlist items in turn, from the current item
+func (m *Manager) StartPlaylist(roomName string) (Playlist, error) {
+	return m.controlPlaylist(roomName, func(room *Room) error {
+		if len(room.playlist.playlist.Items) == 0 {
+			return ErrPlaylistEmpty
+		}
+		room.playlist.playlist.Playing = true
+		m.showPlaylistItem(room)
+		return nil
+	})
+}
+
+// PausePlaylist stops the playlist on its current item
+func (m *Manager) PausePlaylist(roomName string) (Playlist, error) {
+	return m.controlPlaylist(roomName, func(room *Room) error {
+		m.stopPlaylist(room)
+		return nil
+	})
+}
+
+// SkipPlaylist displays the next playlist item
+func (m *Manager) SkipPlaylist(roomName string) (Playlist, error) {
+	return m.controlPlaylist(roomName, func(room *Room) error {
+		if len(room.playlist.playlist.Items) == 0 {
+			return ErrPlaylistEmpty
+		}
+		m.nextPlaylistItem(room)
+		return nil
+	})
+}
+
+// ResumePlaylists restarts the playlists that were playing when the server stopped
+func (m *Manager) ResumePlaylists() {
+	roomNames, err := m.storage.ListRooms()
+	if err != nil {
+		slog.Error("Unable to resume playlists", "error", err)
+		return
+	}
+
+	for _, roomName := range roomNames {
+		var playlist Playlist
+		if err := loadJSON(m.storage.GetRoomPlaylistPath(roomName), &playlist); err != nil || !playlist.Playing {
+			continue
+		}
+		if _, err := m.StartPlaylist(roomName); err != nil {
+			slog.Error("Unable to resume playlist", "room", roomName, "error", err)
+			continue
+		}
+		slog.Info("Playlist resumed", "room", roomName)
+	}
+}
+
+// controlPlaylist applies a state change to the playlist of a room and persists it
+func (m *Manager) controlPlaylist(roomName string, change func(room *Room) error) (Playlist, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	room.playlistMu.Lock()
+	defer m.publishPlaylist(room)
+	defer room.playlistMu.Unlock()
+
+	if err := change(room); err != nil {
+		return Playlist{}, err
+	}
+	if err := m.savePlaylist(room); err != nil {
+		return Playlist{}, err
+	}
+	return room.playlist.playlist.clone(), nil
+}
+
+// showPlaylistItem shows the current playlist item and schedules the next one if playing
+// The playlist lock must be held, and publishPlaylist called once it is released.
+func (m *Manager) showPlaylistItem(room *Room) {
+	state := &room.playlist
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+	if state.closed || len(state.playlist.Items) == 0 {
+		return
+	}
+
+	state.playlist.clampCurrent()
+	item := state.playlist.Items[state.playlist.Current]
+	state.shown++
+
+	if state.playlist.Playing {
+		state.timer = time.AfterFunc(time.Duration(item.Duration)*time.Second, func() {
+			room.playlistMu.Lock()
+			defer m.publishPlaylist(room)
+			defer room.playlistMu.Unlock()
+			if state.playlist.Playing && !state.closed && !m.roomDeleted(room) {
+				m.nextPlaylistItem(room)
+				if err := m.savePlaylist(room); err != nil {
+					slog.Error("Unable to save playlist", "room", room.Name, "error", err)
+				}
+			}
+		})
+	}
+}
+
+// nextPlaylistItem moves to the next playlist item and shows it
+// The playlist lock must be held.
+func (m *Manager) nextPlaylistItem(room *Room) {
+	state := &room.playlist
+	state.playlist.Current = (state.playlist.Current + 1) % len(state.playlist.Items)
+	m.showPlaylistItem(room)
+}
+
+// stopPlaylist stops the playlist scheduling
+// The playlist lock must be held.
+func (m *Manager) stopPlaylist(room *Room) {
+	state := &room.playlist
+	state.playlist.Playing = false
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+}
+
+// publishPlaylist publishes the last playlist item shown, unless already published
+// The publication runs without the playlist lock, and publications are serialized so that an item shown before
+// another is not published after it.
+func (m *Manager) publishPlaylist(room *Room) {
+	room.playlistPublishMu.Lock()
+	defer room.playlistPublishMu.Unlock()
+
+	room.playlistMu.Lock()
+	state := &room.playlist
+	if state.closed || state.published == state.shown || len(state.playlist.Items) == 0 {
+		room.playlistMu.Unlock()
+		return
+	}
+	state.published = state.shown
+	item := state.playlist.Items[state.playlist.Current]
+	room.playlistMu.Unlock()
+
+	if err := m.publishPlaylistItem(room, item); err != nil {
+		slog.Error("Unable to publish playlist item", "room", room.Name, "item", item.ID, "error", err)
+	}
+}
+
+// publishPlaylistItem publishes the image of a playlist item as the main room image
+func (m *Manager) publishPlaylistItem(room *Room, item PlaylistItem) error {
+	file, err := os.Open(m.storage.GetPlaylistItemPath(room.Name, item.ID))
+	if err != nil {
+		return fmt.Errorf("failed to open playlist item: %w", err)
+	}
+	defer file.Close()
+
+	_, err = m.publish(room, "", "", file, true)
+	return err
+}
+
+// savePlaylist persists the playlist of a room
+// The playlist lock must be held.
+func (m *Manager) savePlaylist(room *Room) error {
+	return saveJSON(m.storage.GetRoomPlaylistPath(room.Name), room.playlist.playlist)
+}
+
+// closePlaylist stops the playlist scheduling of an unloaded room, without persisting its state
+func (room *Room) closePlaylist() {
+	room.playlistMu.Lock()
+	defer room.playlistMu.Unlock()
+
+	room.playlist.closed = true
+	if room.playlist.timer != nil {
+		room.playlist.timer.Stop()
+		room.playlist.timer = nil
+	}
+}
+
+// clampCurrent resets the current item to the first one when out of range, as in a playlist file edited by hand
+func (p *Playlist) clampCurrent() {
+	if p.Current < 0 || p.Current >= len(p.Items) {
+		p.Current = 0
+	}
+}
+
+// clone returns a copy of the playlist not sharing its items
+func (p Playlist) clone() Playlist {
+	if p.Items == nil {
+		p.Items = []PlaylistItem{}
+	}
+	p.Items = append([]PlaylistItem(nil), p.Items...)
+	return p
+}
+
+// newItemID generates a random identifier
+func newItemID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
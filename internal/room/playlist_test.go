@@ -0,0 +1,47 @@
+package room
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"testing"
+)
+
+func TestPlaylistCurrentOutOfRange(t *testing.T) {
+	m := newTestManager(t)
+	newTestRoom(t, m, "lobby", Settings{})
+
+	images := [][]byte{testPNG(t, color.White), testPNG(t, color.Black)}
+	for _, data := range images {
+		if _, err := m.AddPlaylistItem("lobby", "", 0, bytes.NewReader(data)); err != nil {
+			t.Fatalf("AddPlaylistItem: %v", err)
+		}
+	}
+	playlist, err := m.GetPlaylist("lobby")
+	if err != nil {
+		t.Fatalf("GetPlaylist: %v", err)
+	}
+
+	// A playlist file edited by hand, resumed on startup
+	m.unloadRoom("lobby")
+	playlist.Playing, playlist.Current = true, 5
+	if err := saveJSON(m.storage.GetRoomPlaylistPath("lobby"), playlist); err != nil {
+		t.Fatal(err)
+	}
+	m.ResumePlaylists()
+	t.Cleanup(func() { m.PausePlaylist("lobby") })
+
+	if playlist, err = m.GetPlaylist("lobby"); err != nil {
+		t.Fatalf("GetPlaylist: %v", err)
+	}
+	if !playlist.Playing || playlist.Current != 0 {
+		t.Errorf("playlist playing %t on item %d, want playing on item 0", playlist.Playing, playlist.Current)
+	}
+	live, err := os.ReadFile(m.storage.GetRoomImagePath("lobby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(live, images[0]) {
+		t.Error("live image is not the first playlist item")
+	}
+}
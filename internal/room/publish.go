@@ -0,0 +1,231 @@
+package room
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+// ErrEmptyImage is returned when publishing an empty image
+var ErrEmptyImage = errors.New("empty image")
+
+// Publish saves an image as the live image of a room slot and notifies the room viewers
+// The empty slot designates the main room image. When the room skips duplicates and the image matches the
+// current one, nothing is saved nor notified and false is returned. Images vetoed by a pre-publish hook are
+// rejected with a *hook.RejectedError.
+func (m *Manager) Publish(room *Room, slot string, reader io.Reader) (bool, error) {
+	return m.PublishFile(room, slot, "", reader)
+}
+
+// PublishFile publishes an image like Publish, keeping it in the room publication history under the given file
+// name (a name is derived from the publication time if empty)
+func (m *Manager) PublishFile(room *Room, slot, name string, reader io.Reader) (bool, error) {
+	return m.publish(room, slot, name, reader, false)
+}
+
+// applyHooks passes an image accepted for a later publication through the pre-publish hooks of a room,
+// and returns the image to store
+// Images vetoed by a hook are rejected with a *hook.RejectedError.
+func (m *Manager) applyHooks(room *Room, slot string, reader io.Reader) (io.Reader, error) {
+	reader, err := nonEmpty(reader)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	hooks := m.hooks
+	m.mu.RUnlock()
+	if !hooks.Applies(room.Name) {
+		return reader, nil
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if data, err = hooks.Run(context.Background(), room.Name, slot, data); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// publish publishes an image, running the pre-publish hooks unless they already ran when it was accepted
+func (m *Manager) publish(room *Room, slot, name string, reader io.Reader, hooked bool) (bool, error) {
+	if name != "" {
+		if err := validateFileName(name); err != nil {
+			return false, err
+		}
+	}
+	reader, err := nonEmpty(reader)
+	if err != nil {
+		return false, err
+	}
+	// Publications to a slot are serialized, so that the duplicate detection, the version and the history
+	// entry all refer to the image this call publishes
+	unlock := room.lockSlot(slot)
+	defer unlock()
+
+	settings := room.Settings()
+	overlay := m.hasOverlay(room, settings)
+	m.mu.RLock()
+	hooks := m.hooks
+	m.mu.RUnlock()
+	runHooks := !hooked && hooks.Applies(room.Name)
+	history := historySize(settings, name != "")
+
+	// Uploads are hashed to detect duplicates of the current image
+	hasher := sha256.New()
+	reader = io.TeeReader(reader, hasher)
+	version := Version{PublishedAt: time.Now().UTC()}
+
+	// Apply the duplicate detection, the pre-publish hooks, the metadata processing and the overlays of the
+	// room, if any, and keep the published image for the history
+	var report *imaging.MetadataReport
+	var original, published []byte
+	if settings.SkipDuplicates || runHooks || settings.AutoOrient || settings.StripMetadata || overlay || history > 0 {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return false, fmt.Errorf("failed to read image: %w", err)
+		}
+		version.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+
+		if settings.SkipDuplicates {
+			if settings.SimilarityThreshold > 0 {
+				if img, err := imaging.Decode(bytes.NewReader(data)); err == nil {
+					version.DHash = fmt.Sprintf("%016x", imaging.DifferenceHash(img))
+				}
+			}
+			previous, err := m.loadVersion(room, slot)
+			if err != nil {
+				slog.Warn("Unable to load image version", "room", room.Name, "slot", slot, "error", err)
+			}
+			if isDuplicate(settings, previous, version) {
+				slog.Debug("Duplicate image skipped", "room", room.Name, "slot", slot)
+				return false, nil
+			}
+		}
+
+		if runHooks {
+			if data, err = hooks.Run(context.Background(), room.Name, slot, data); err != nil {
+				return false, err
+			}
+		}
+
+		if data, report, original, err = m.processImage(room, slot, settings, overlay, data); err != nil {
+			return false, err
+		}
+		published = data
+		reader = bytes.NewReader(data)
+	}
+
+	if err := m.storage.SaveImage(room.Name, slot, reader); err != nil {
+		return false, err
+	}
+
+	if version.SHA256 == "" {
+		version.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	}
+	if err := m.saveVersion(room, slot, version); err != nil {
+		slog.Warn("Unable to save image version", "room", room.Name, "slot", slot, "error", err)
+	}
+
+	if history > 0 {
+		if err := m.recordHistory(room, slot, name, version.PublishedAt, published, history); err != nil {
+			slog.Warn("Unable to record image history", "room", room.Name, "slot", slot, "error", err)
+		}
+	}
+
+	// The image as uploaded is kept for room members when overlays were applied
+	if original != nil {
+		if err := m.storage.SaveOriginalImage(room.Name, slot, bytes.NewReader(original)); err != nil {
+			slog.Warn("Unable to save original image", "room", room.Name, "slot", slot, "error", err)
+		}
+	} else if err := m.storage.DeleteOriginalImage(room.Name, slot); err != nil {
+		slog.Warn("Unable to delete original image", "room", room.Name, "slot", slot, "error", err)
+	}
+
+	if err := m.saveMetadataReport(room, slot, report); err != nil {
+		slog.Warn("Unable to save metadata report", "room", room.Name, "slot", slot, "error", err)
+	}
+
+	// Renditions and thumbnails of the previous image are obsolete
+	if err := m.storage.DeleteRenditions(room.Name, slot); err != nil {
+		slog.Warn("Unable to delete renditions", "room", room.Name, "slot", slot, "error", err)
+	}
+	if err := m.storage.DeleteThumbnails(room.Name, slot); err != nil {
+		slog.Warn("Unable to delete thumbnails", "room", room.Name, "slot", slot, "error", err)
+	}
+	if err := m.generateThumbnails(room, slot); errors.Is(err, imaging.ErrUnsupportedImage) {
+		slog.Debug("No thumbnails for unsupported image", "room", room.Name, "slot", slot)
+	} else if err != nil {
+		slog.Warn("Unable to generate thumbnails", "room", room.Name, "slot", slot, "error", err)
+	}
+
+	slog.Debug("Image published", "room", room.Name, "slot", slot)
+
+	// Notify all connected clients, the event listeners and the webhooks
+	room.broadcaster.NotifySlot(slot)
+	m.notifyEvent(room.Name, EventImagePublished, slot)
+	return true, nil
+}
+
+// lockSlot locks the publications to a room slot, and returns the function unlocking them
+func (r *Room) lockSlot(slot string) func() {
+	r.publishMu.Lock()
+	if r.slotLocks == nil {
+		r.slotLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := r.slotLocks[slot]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.slotLocks[slot] = lock
+	}
+	r.publishMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// nonEmpty returns a reader of an image, failing with ErrEmptyImage if it holds no data
+func nonEmpty(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	if _, err := buffered.Peek(1); errors.Is(err, io.EOF) {
+		return nil, ErrEmptyImage
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	return buffered, nil
+}
+
+// processImage applies the metadata processing and the overlays of a room to an uploaded image
+// It returns the image to publish, the metadata report if the metadata was processed, and the image before
+// its overlays if they were applied.
+func (m *Manager) processImage(room *Room, slot string, settings Settings, overlay bool, data []byte) ([]byte, *imaging.MetadataReport, []byte, error) {
+	var report *imaging.MetadataReport
+	if settings.AutoOrient || settings.StripMetadata {
+		cleaned, cleanReport, err := imaging.Clean(data, settings.AutoOrient, settings.StripMetadata)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to process image metadata: %w", err)
+		}
+		data, report = cleaned, &cleanReport
+	}
+
+	// Images of rooms with overlays are never published without them
+	if overlay {
+		composed, err := m.applyOverlay(room, slot, settings, data)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to apply overlays: %w", err)
+		}
+		return composed, report, data, nil
+	}
+	return data, report, nil, nil
+}
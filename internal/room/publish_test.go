@@ -0,0 +1,103 @@
+package room
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/hook"
+)
+
+// countingHook returns a hook replacing every image by another one, and the file counting its runs
+func countingHook(t *testing.T, replacement []byte) (hook.Hook, string) {
+	t.Helper()
+	dir := t.TempDir()
+	countFile, imageFile := filepath.Join(dir, "count"), filepath.Join(dir, "image.png")
+	if err := os.WriteFile(imageFile, replacement, 0644); err != nil {
+		t.Fatal(err)
+	}
+	command := []string{"sh", "-c", `cat >/dev/null; echo run >> "$0"; cat "$1"`, countFile, imageFile}
+	return hook.Hook{Name: "replace", Command: command, Timeout: 5 * time.Second}, countFile
+}
+
+// hookRuns returns the number of runs of a counting hook
+func hookRuns(t *testing.T, countFile string) int {
+	t.Helper()
+	content, err := os.ReadFile(countFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(content), "run")
+}
+
+func TestDeferredPublicationsRejectedByHooks(t *testing.T) {
+	m := newTestManager(t)
+	m.SetHooks(hook.NewChain([]hook.Hook{{Name: "moderation", Command: []string{"sh", "-c", "echo 'not allowed' >&2; exit 1"}, Timeout: 5 * time.Second}}))
+	room := newTestRoom(t, m, "lobby", Settings{})
+	image := testPNG(t, color.White)
+
+	var rejected *hook.RejectedError
+	_, err := m.SchedulePublication(room, "", time.Now().Add(time.Hour), "alice", bytes.NewReader(image))
+	if !errors.As(err, &rejected) || rejected.Reason != "not allowed" {
+		t.Errorf("SchedulePublication: got %v, want rejection", err)
+	}
+	if publications, _ := m.ListPublications("lobby"); len(publications) != 0 {
+		t.Errorf("rejected publication scheduled: %v", publications)
+	}
+
+	if _, err := m.AddPlaylistItem("lobby", "", 0, bytes.NewReader(image)); !errors.As(err, &rejected) {
+		t.Errorf("AddPlaylistItem: got %v, want rejection", err)
+	}
+	if playlist, _ := m.GetPlaylist("lobby"); len(playlist.Items) != 0 {
+		t.Errorf("rejected item added: %v", playlist.Items)
+	}
+}
+
+func TestDeferredPublicationsHookedOnce(t *testing.T) {
+	m := newTestManager(t)
+	replacement := testPNG(t, color.Black)
+	replace, countFile := countingHook(t, replacement)
+	m.SetHooks(hook.NewChain([]hook.Hook{replace}))
+	room := newTestRoom(t, m, "lobby", Settings{})
+	image := testPNG(t, color.White)
+
+	// Playlist items are hooked when added, not at each rotation
+	item, err := m.AddPlaylistItem("lobby", "", 0, bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("AddPlaylistItem: %v", err)
+	}
+	for range 3 {
+		if err := m.publishPlaylistItem(room, item); err != nil {
+			t.Fatalf("publishPlaylistItem: %v", err)
+		}
+	}
+	if runs := hookRuns(t, countFile); runs != 1 {
+		t.Errorf("playlist item hooked %d times, want 1", runs)
+	}
+	if live, _ := os.ReadFile(m.storage.GetRoomImagePath("lobby")); !bytes.Equal(live, replacement) {
+		t.Error("playlist item published without the hook output")
+	}
+
+	// Scheduled publications are hooked when scheduled, not when published
+	publication, err := m.SchedulePublication(room, "cam1", time.Now().Add(time.Hour), "alice", bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("SchedulePublication: %v", err)
+	}
+	if err := m.publishPending(room, publication); err != nil {
+		t.Fatalf("publishPending: %v", err)
+	}
+	if runs := hookRuns(t, countFile); runs != 2 {
+		t.Errorf("hooks run %d times, want 2", runs)
+	}
+	if live, _ := os.ReadFile(m.storage.GetSlotImagePath("lobby", "cam1")); !bytes.Equal(live, replacement) {
+		t.Error("scheduled image published without the hook output")
+	}
+}
@@ -0,0 +1,67 @@
+package room
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+const (
+	// maxRenditions is the maximum number of renditions cached for the image of a room slot
+	maxRenditions = 16
+	// maxConcurrentRenders is the maximum number of renditions generated at the same time
+	maxConcurrentRenders = 2
+)
+
+// Rendition returns the path to a rendition of the image of a room slot, generating it if not cached
+// Renditions are keyed by the image version (its modification time), so that a new upload invalidates them.
+// Identical requests share a single generation, and at most maxRenditions renditions are kept per image.
+func (m *Manager) Rendition(room *Room, slot string, opts imaging.Options) (string, error) {
+	imagePath := m.storage.GetSlotImagePath(room.Name, slot)
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%x-%s", info.ModTime().UnixNano(), opts.Key())
+	path := m.storage.GetRenditionPath(room.Name, slot, name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	_, err, _ = m.renders.Do(path, func() (any, error) {
+		m.renderSlots <- struct{}{}
+		defer func() { <-m.renderSlots }()
+		return nil, m.render(room, slot, imagePath, name, opts)
+	})
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// render generates a rendition of the image of a room slot, evicting the oldest renditions beyond the limit
+func (m *Manager) render(room *Room, slot, imagePath, name string, opts imaging.Options) error {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var rendition bytes.Buffer
+	if err := imaging.Render(&rendition, file, opts); err != nil {
+		return err
+	}
+	if err := m.storage.SaveRendition(room.Name, slot, name, &rendition); err != nil {
+		return err
+	}
+	if err := m.storage.PruneRenditions(room.Name, slot, maxRenditions); err != nil {
+		slog.Warn("Unable to prune renditions", "room", room.Name, "slot", slot, "error", err)
+	}
+
+	slog.Debug("Rendition generated", "room", room.Name, "slot", slot, "rendition", name)
+	return nil
+}
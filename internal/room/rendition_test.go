@@ -0,0 +1,69 @@
+package room
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+func TestRenditionCacheBounded(t *testing.T) {
+	m := newTestManager(t)
+	room := newTestRoom(t, m, "lobby", Settings{})
+	if _, err := m.Publish(room, "", bytes.NewReader(testPNG(t, color.White))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	// More small renditions than the cache holds
+	for _, width := range imaging.Dimensions[:8] {
+		for _, format := range []string{"png", "jpeg", "gif"} {
+			path, err := m.Rendition(room, "", imaging.Options{Width: width, Fit: "fill", Format: format})
+			if err != nil {
+				t.Fatalf("Rendition: %v", err)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Fatalf("rendition %dx0 %s not cached: %v", width, format, err)
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(m.storage.GetRenditionPath("lobby", "", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > maxRenditions {
+		t.Errorf("%d renditions cached, want at most %d", len(entries), maxRenditions)
+	}
+}
+
+func TestRenditionConcurrentRequests(t *testing.T) {
+	m := newTestManager(t)
+	room := newTestRoom(t, m, "lobby", Settings{})
+	if _, err := m.Publish(room, "", bytes.NewReader(testPNG(t, color.White))); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	opts := imaging.Options{Width: 64, Height: 64, Fit: "cover", Format: "png"}
+	paths := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			path, err := m.Rendition(room, "", opts)
+			if err != nil {
+				t.Errorf("Rendition: %v", err)
+			}
+			paths[i] = path
+		}()
+	}
+	wg.Wait()
+	for _, path := range paths[1:] {
+		if path != paths[0] {
+			t.Fatalf("renditions %s and %s, want the same", paths[0], path)
+		}
+	}
+}
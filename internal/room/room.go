@@ -1,102 +1,386 @@
 package room
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 
 	"github.com/ncarlier/imgcast/internal/auth"
 	"github.com/ncarlier/imgcast/internal/broadcaster"
+	"github.com/ncarlier/imgcast/internal/fetch"
+	"github.com/ncarlier/imgcast/internal/hook"
 	"github.com/ncarlier/imgcast/internal/storage"
 	"github.com/ncarlier/imgcast/pkg/validator"
 )
 
+var (
+	// ErrRoomNotFound is returned when a room does not exist
+	ErrRoomNotFound = errors.New("room does not exist")
+	// ErrRoomExists is returned when creating a room that already exists
+	ErrRoomExists = errors.New("room already exists")
+	// ErrUnauthorized is returned when authentication fails
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrUnreachableRooms is returned when existing rooms have names that are no longer valid
+	ErrUnreachableRooms = errors.New("rooms cannot be reached")
+)
+
 // Room represents a multi-room instance
+// The room name is a path including its namespaces ("acme/marketing/lobby")
 type Room struct {
-	Name        string
-	broadcaster *broadcaster.Broadcaster
-	auth        *auth.Authenticator
+	Name              string
+	broadcaster       *broadcaster.Broadcaster
+	auth              *auth.Authenticator
+	inherited         []*auth.Authenticator
+	settings          Settings
+	mu                sync.RWMutex
+	playlist          playlistState
+	playlistMu        sync.Mutex
+	playlistPublishMu sync.Mutex
+	pending           pendingState
+	pendingMu         sync.Mutex
+	source            sourceState
+	sourceMu          sync.Mutex
+	historyMu         sync.Mutex
+	publishMu         sync.Mutex
+	slotLocks         map[string]*sync.Mutex
+}
+
+// Member is a room user to register at room creation
+type Member struct {
+	Username string
+	Password string
+}
+
+// Options holds the initial configuration of a room being created
+type Options struct {
+	Settings Settings
+	Members  []Member
+	// TTL is the lifetime of the room, after which it is garbage collected (zero means no expiry)
+	TTL time.Duration
+	// Webhooks are subscribed to the room events, including its creation
+	Webhooks []Webhook
+}
+
+// Summary describes a room without loading it
+type Summary struct {
+	Name       string    `json:"name"`
+	Settings   Settings  `json:"settings"`
+	LastUpdate time.Time `json:"last_update"`
 }
 
 // Manager manages multiple rooms
 type Manager struct {
-	rooms     map[string]*Room
-	storage   *storage.Storage
-	adminAuth *auth.Authenticator
-	mu        sync.RWMutex
+	rooms          map[string]*Room
+	storage        *storage.Storage
+	adminAuth      *auth.Authenticator
+	autoCreate     bool
+	thumbnailSizes []int
+	// renders deduplicates the concurrent generations of a rendition, bounded by renderSlots
+	renders     singleflight.Group
+	renderSlots chan struct{}
+	// fetcher pulls the room sources
+	fetcher *fetch.Fetcher
+	// webhookFetcher delivers the webhooks, with its own address restrictions
+	webhookFetcher *fetch.Fetcher
+	sourcesStarted bool
+	// hooks validate or transform the images before they are published
+	hooks *hook.Chain
+	// listeners are called with the events of all rooms
+	listeners  []func(Event)
+	webhooksMu sync.Mutex
+	tokensMu   sync.Mutex
+	mu         sync.RWMutex
 }
 
 // NewManager creates a new room manager
-func NewManager(storage *storage.Storage, adminAuth *auth.Authenticator) *Manager {
+// If autoCreate is true, rooms are created on first upload by an admin. Thumbnails of the given sizes are
+// generated for each published image.
+func NewManager(storage *storage.Storage, adminAuth *auth.Authenticator, autoCreate bool, thumbnailSizes []int) *Manager {
 	return &Manager{
-		rooms:     make(map[string]*Room),
-		storage:   storage,
-		adminAuth: adminAuth,
+		rooms:          make(map[string]*Room),
+		storage:        storage,
+		adminAuth:      adminAuth,
+		autoCreate:     autoCreate,
+		thumbnailSizes: slices.Sorted(slices.Values(thumbnailSizes)),
+		renderSlots:    make(chan struct{}, maxConcurrentRenders),
 	}
 }
 
+// SetFetcher sets the fetcher pulling the room sources
+// Without fetcher, room sources are not pulled.
+func (m *Manager) SetFetcher(fetcher *fetch.Fetcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetcher = fetcher
+}
+
+// SetWebhookFetcher sets the fetcher delivering the webhooks
+// Without fetcher, webhooks are not delivered.
+func (m *Manager) SetWebhookFetcher(fetcher *fetch.Fetcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhookFetcher = fetcher
+}
+
+// SetHooks sets the pre-publish hooks run on every published image
+func (m *Manager) SetHooks(hooks *hook.Chain) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = hooks
+}
+
 // GetRoom retrieves a room by name, creating it if it doesn't exist (for viewing)
 func (m *Manager) GetRoom(roomName string) (*Room, error) {
 	// Validate room name
-	if !validator.IsValidRoomName(roomName) {
-		return nil, fmt.Errorf("invalid room name: must be alphanumeric with dash/underscore only")
+	roomName, err := normalizeRoomName(roomName)
+	if err != nil {
+		return nil, err
 	}
 
 	m.mu.RLock()
 	room, exists := m.rooms[roomName]
 	m.mu.RUnlock()
 
-	if exists {
-		return room, nil
-	}
-
 	// Check if room exists on disk
 	if !m.storage.RoomExists(roomName) {
-		return nil, fmt.Errorf("room does not exist")
+		if exists {
+			// Room directory was removed behind our back
+			m.unloadRoom(roomName)
+		}
+		return nil, ErrRoomNotFound
+	}
+
+	if exists {
+		return room, nil
 	}
 
 	// Create room instance
 	return m.loadRoom(roomName)
 }
 
-// CreateRoom creates a new room with authentication
-func (m *Manager) CreateRoom(roomName, username, password string) (*Room, error) {
+// CreateRoom creates a new room on behalf of an admin or of an admin of one of its parent namespaces
+// The admin is registered as the first room member, followed by the members of the options
+func (m *Manager) CreateRoom(roomName, username, password string, opts Options) (*Room, error) {
 	// Validate room name
-	if !validator.IsValidRoomName(roomName) {
-		return nil, fmt.Errorf("invalid room name: must be alphanumeric with dash/underscore only")
+	roomName, err := normalizeRoomName(roomName)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check if room already exists
 	if m.storage.RoomExists(roomName) {
-		return nil, fmt.Errorf("room already exists")
+		return nil, ErrRoomExists
 	}
 
-	// Authenticate against admin htpasswd
-	authenticated, err := m.adminAuth.Authenticate(username, password)
+	// Authenticate against admin htpasswd or parent namespaces admins
+	if err := m.AuthenticateNamespaceAdmin(NamespaceOf(roomName), username, password); err != nil {
+		return nil, err
+	}
+
+	opts.Members = append([]Member{{Username: username, Password: password}}, opts.Members...)
+	room, err := m.ProvisionRoom(roomName, opts)
 	if err != nil {
-		return nil, fmt.Errorf("authentication error: %w", err)
+		return nil, err
 	}
-	if !authenticated {
-		return nil, fmt.Errorf("admin authentication failed")
+
+	slog.Info("Room created", "room", roomName, "creator", username)
+
+	return room, nil
+}
+
+// ProvisionRoom creates a new room without admin authentication
+// It is intended for local administration tasks having direct access to the storage
+func (m *Manager) ProvisionRoom(roomName string, opts Options) (*Room, error) {
+	// Validate room name
+	roomName, err := normalizeRoomName(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if room already exists
+	if m.storage.RoomExists(roomName) || m.storage.NamespaceExists(roomName) {
+		return nil, ErrRoomExists
+	}
+
+	// Nested rooms must be created inside an existing namespace
+	if err := m.checkParentNamespace(roomName); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Settings.Validate(); err != nil {
+		return nil, err
+	}
+	for _, member := range opts.Members {
+		if err := auth.ValidateUsername(member.Username); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Webhooks) > maxWebhooks {
+		return nil, fmt.Errorf("%w: at most %d", ErrWebhooksFull, maxWebhooks)
+	}
+	webhooks := make([]Webhook, 0, len(opts.Webhooks))
+	for _, webhook := range opts.Webhooks {
+		webhook, err := newWebhook(webhook)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
 	}
 
 	// Create room directory
 	if err := m.storage.CreateRoom(roomName); err != nil {
 		return nil, fmt.Errorf("failed to create room: %w", err)
 	}
+	room, err := m.provisionRoomFiles(roomName, opts, webhooks)
+	if err != nil {
+		// No half-provisioned room is left behind
+		if err := m.storage.DeleteRoom(roomName); err != nil {
+			slog.Error("Unable to remove partially created room", "room", roomName, "error", err)
+		}
+		return nil, err
+	}
+	m.dispatchEvent(roomName, webhooks, EventRoomCreated, "")
+	return room, nil
+}
 
-	// Create room htpasswd with the authenticated admin user
+// provisionRoomFiles writes the authentication, settings and webhooks of a new room, then loads it
+func (m *Manager) provisionRoomFiles(roomName string, opts Options, webhooks []Webhook) (*Room, error) {
+	// Create room htpasswd with the initial members
 	roomAuth := auth.NewAuthenticator(m.storage.GetRoomHtpasswdPath(roomName))
-	if err := roomAuth.CreateWithUser(username, password); err != nil {
+	if err := roomAuth.Create(); err != nil {
 		return nil, fmt.Errorf("failed to create room authentication: %w", err)
 	}
+	for _, member := range opts.Members {
+		if err := roomAuth.SetUser(member.Username, member.Password); err != nil {
+			return nil, fmt.Errorf("failed to add room member: %w", err)
+		}
+	}
 
-	slog.Info("Room created", "room", roomName, "creator", username)
+	// Store initial settings
+	if opts.TTL > 0 {
+		expiresAt := time.Now().Add(opts.TTL).UTC()
+		opts.Settings.ExpiresAt = &expiresAt
+	}
+	if err := saveSettings(m.storage.GetRoomSettingsPath(roomName), opts.Settings); err != nil {
+		return nil, fmt.Errorf("failed to store room settings: %w", err)
+	}
+	if len(webhooks) > 0 {
+		if err := saveJSON(m.storage.GetRoomWebhooksPath(roomName), webhooks); err != nil {
+			return nil, fmt.Errorf("failed to store room webhooks: %w", err)
+		}
+	}
 
 	// Load the room
 	return m.loadRoom(roomName)
 }
 
+// AuthenticateAdmin authenticates a user against the admin htpasswd
+func (m *Manager) AuthenticateAdmin(username, password string) error {
+	authenticated, err := m.adminAuth.Authenticate(username, password)
+	if err != nil {
+		return fmt.Errorf("authentication error: %w", err)
+	}
+	if !authenticated {
+		return fmt.Errorf("admin authentication failed: %w", ErrUnauthorized)
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room: viewers are notified and disconnected, then the room data is removed
+func (m *Manager) DeleteRoom(roomName string) error {
+	// Validate room name
+	roomName, err := normalizeRoomName(roomName)
+	if err != nil {
+		return err
+	}
+
+	if !m.storage.RoomExists(roomName) {
+		m.unloadRoom(roomName)
+		return ErrRoomNotFound
+	}
+
+	m.unloadRoom(roomName)
+
+	// Listeners and webhooks are notified once the room is deleted
+	m.webhooksMu.Lock()
+	webhooks, err := m.loadWebhooks(roomName)
+	if err != nil {
+		slog.Warn("Unable to load webhooks", "room", roomName, "error", err)
+	}
+	if err := m.storage.DeleteRoom(roomName); err != nil {
+		m.webhooksMu.Unlock()
+		return err
+	}
+	m.webhooksMu.Unlock()
+	m.dispatchEvent(roomName, webhooks, EventRoomDeleted, "")
+
+	slog.Info("Room deleted", "room", roomName)
+	return nil
+}
+
+// UpdateSettings replaces the settings of a room and persists them
+// The expiry date of the room is preserved.
+func (m *Manager) UpdateSettings(roomName string, settings Settings) (Settings, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	if err := settings.Validate(); err != nil {
+		return Settings{}, err
+	}
+
+	room.mu.Lock()
+	previous := room.settings
+	settings.ExpiresAt = previous.ExpiresAt
+	if err := saveSettings(m.storage.GetRoomSettingsPath(room.Name), settings); err != nil {
+		room.mu.Unlock()
+		return Settings{}, err
+	}
+	room.settings = settings
+	room.mu.Unlock()
+
+	// A new source is pulled right away
+	if settings.SourceURL != previous.SourceURL || settings.SourceInterval != previous.SourceInterval {
+		m.mu.RLock()
+		fetcher := m.sourceFetcher()
+		m.mu.RUnlock()
+		m.armSource(room, fetcher)
+	}
+
+	slog.Info("Room settings updated", "room", room.Name)
+	return settings, nil
+}
+
+// ListRooms returns the summary of all rooms, by name
+func (m *Manager) ListRooms() ([]Summary, error) {
+	roomNames, err := m.storage.ListRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]Summary, 0, len(roomNames))
+	for _, roomName := range roomNames {
+		settings, err := loadSettings(m.storage.GetRoomSettingsPath(roomName))
+		if err != nil {
+			slog.Warn("Unable to load room settings", "room", roomName, "error", err)
+			continue
+		}
+		lastUpdate, err := m.storage.LastUpdate(roomName)
+		if err != nil {
+			slog.Warn("Unable to get room last update", "room", roomName, "error", err)
+		}
+		summaries = append(summaries, Summary{Name: roomName, Settings: settings, LastUpdate: lastUpdate})
+	}
+	return summaries, nil
+}
+
 // AuthenticateForRoom authenticates a user against a room's htpasswd
 func (m *Manager) AuthenticateForRoom(roomName, username, password string) (bool, error) {
 	room, err := m.GetRoom(roomName)
@@ -104,14 +388,43 @@ func (m *Manager) AuthenticateForRoom(roomName, username, password string) (bool
 		return false, err
 	}
 
-	return room.auth.Authenticate(username, password)
+	return room.Authenticate(username, password)
+}
+
+// SetMember adds a member to a room, or updates the password of an existing member
+func (m *Manager) SetMember(roomName, username, password string) error {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return err
+	}
+	if err := room.auth.SetUser(username, password); err != nil {
+		return err
+	}
+
+	slog.Info("Room member set", "room", room.Name, "user", username)
+	return nil
+}
+
+// RemoveMember removes a member from a room
+func (m *Manager) RemoveMember(roomName, username string) error {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return err
+	}
+	if err := room.auth.RemoveUser(username); err != nil {
+		return err
+	}
+
+	slog.Info("Room member removed", "room", room.Name, "user", username)
+	return nil
 }
 
 // GetOrCreateRoom gets a room if it exists, or creates it if the user is an admin
 func (m *Manager) GetOrCreateRoom(roomName, username, password string) (*Room, bool, error) {
 	// Validate room name
-	if !validator.IsValidRoomName(roomName) {
-		return nil, false, fmt.Errorf("invalid room name: must be alphanumeric with dash/underscore only")
+	roomName, err := normalizeRoomName(roomName)
+	if err != nil {
+		return nil, false, err
 	}
 
 	// Check if room exists
@@ -122,19 +435,22 @@ func (m *Manager) GetOrCreateRoom(roomName, username, password string) (*Room, b
 			return nil, false, err
 		}
 
-		authenticated, err := room.auth.Authenticate(username, password)
+		authenticated, err := room.Authenticate(username, password)
 		if err != nil {
 			return nil, false, fmt.Errorf("authentication error: %w", err)
 		}
 		if !authenticated {
-			return nil, false, fmt.Errorf("room authentication failed")
+			return nil, false, fmt.Errorf("room authentication failed: %w", ErrUnauthorized)
 		}
 
 		return room, false, nil
 	}
 
-	// Room doesn't exist, try to create it (requires admin auth)
-	room, err := m.CreateRoom(roomName, username, password)
+	// Room doesn't exist, try to create it (requires admin auth) if allowed
+	if !m.autoCreate {
+		return nil, false, ErrRoomNotFound
+	}
+	room, err := m.CreateRoom(roomName, username, password, Options{})
 	if err != nil {
 		return nil, false, err
 	}
@@ -142,6 +458,54 @@ func (m *Manager) GetOrCreateRoom(roomName, username, password string) (*Room, b
 	return room, true, nil
 }
 
+// MigrateRoomNames renames the rooms and namespaces created with upper case letters to their lower case name,
+// as room paths are case-insensitive and looked up in lower case
+// Rooms whose lower case name is already taken cannot be reached until renamed by hand, and are reported.
+func (m *Manager) MigrateRoomNames() error {
+	renamed, conflicts, err := m.storage.LowercaseRoomDirs()
+	if err != nil {
+		return err
+	}
+	for from, to := range renamed {
+		slog.Info("Room directory renamed to lower case", "from", from, "to", to)
+	}
+	for _, path := range conflicts {
+		slog.Error("Room directory with upper case letters cannot be reached, as its lower case name is already taken: rename or merge it by hand",
+			"path", path, "expected", strings.ToLower(path))
+	}
+	return nil
+}
+
+// CheckRoomNames checks that every existing room can be reached, failing with ErrUnreachableRooms otherwise
+// Rooms created by earlier versions may have names that became reserved by new routes, or that are no longer
+// valid, such as names starting with a dash or an underscore. They are logged so that they can be renamed by hand.
+func (m *Manager) CheckRoomNames() error {
+	rooms, err := m.storage.ListRooms()
+	if err != nil {
+		return err
+	}
+	var unreachable []string
+	for _, roomName := range rooms {
+		if err := validator.ValidateRoomPath(roomName); err != nil {
+			slog.Error("Room cannot be reached, as its name is not valid: rename it by hand", "path", roomName, "error", err)
+			unreachable = append(unreachable, roomName)
+		}
+	}
+	if len(unreachable) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnreachableRooms, strings.Join(unreachable, ", "))
+	}
+	return nil
+}
+
+// normalizeRoomName returns the canonical form of a room path, or an error if it is invalid
+func normalizeRoomName(roomName string) (string, error) {
+	roomName = validator.NormalizeRoomPath(roomName)
+	if err := validator.ValidateRoomPath(roomName); err != nil {
+		return "", err
+	}
+	return roomName, nil
+}
+
 // loadRoom loads an existing room from disk
 func (m *Manager) loadRoom(roomName string) (*Room, error) {
 	m.mu.Lock()
@@ -152,20 +516,122 @@ func (m *Manager) loadRoom(roomName string) (*Room, error) {
 		return room, nil
 	}
 
+	settings, err := loadSettings(m.storage.GetRoomSettingsPath(roomName))
+	if err != nil {
+		return nil, err
+	}
+
+	var playlist Playlist
+	if err := loadJSON(m.storage.GetRoomPlaylistPath(roomName), &playlist); err != nil {
+		return nil, err
+	}
+	// The playlist is resumed explicitly, see ResumePlaylists
+	playlist.Playing = false
+	playlist.clampCurrent()
+
+	var publications []Publication
+	if err := loadJSON(m.storage.GetRoomPendingPath(roomName), &publications); err != nil {
+		return nil, err
+	}
+
+	// Room members inherit from the admins of the parent namespaces
+	var inherited []*auth.Authenticator
+	for _, namespace := range ancestorNamespaces(roomName) {
+		inherited = append(inherited, auth.NewAuthenticator(m.storage.GetNamespaceHtpasswdPath(namespace)))
+	}
+
 	// Create room instance
 	room := &Room{
 		Name:        roomName,
 		broadcaster: broadcaster.New(),
 		auth:        auth.NewAuthenticator(m.storage.GetRoomHtpasswdPath(roomName)),
+		inherited:   inherited,
+		settings:    settings,
+		playlist:    playlistState{playlist: playlist},
+		pending:     pendingState{publications: publications},
 	}
 
+	// Scheduled publications are armed as soon as the room is loaded
+	room.pendingMu.Lock()
+	for _, publication := range publications {
+		m.schedulePublication(room, publication)
+	}
+	room.pendingMu.Unlock()
+
+	// The source is pulled as soon as the room is loaded, once sources are started
+	m.armSource(room, m.sourceFetcher())
+
 	m.rooms[roomName] = room
 	slog.Info("Room loaded", "room", roomName)
 
 	return room, nil
 }
 
+// unloadRoom removes a room from memory and releases its resources
+func (m *Manager) unloadRoom(roomName string) {
+	m.mu.Lock()
+	room, exists := m.rooms[roomName]
+	delete(m.rooms, roomName)
+	m.mu.Unlock()
+
+	if exists {
+		room.close()
+		slog.Info("Room unloaded", "room", roomName)
+	}
+}
+
+// roomDeleted reports whether a loaded room was deleted from the rooms directory, by another process
+// or by hand, and unloads it then, so that its timers stop publishing to a removed room
+func (m *Manager) roomDeleted(room *Room) bool {
+	if m.storage.RoomExists(room.Name) {
+		return false
+	}
+
+	slog.Warn("Room directory removed, unloading room", "room", room.Name)
+	// The room is unloaded asynchronously, as the caller holds locks released by close
+	go func() {
+		m.mu.Lock()
+		loaded := m.rooms[room.Name] == room
+		if loaded {
+			delete(m.rooms, room.Name)
+		}
+		m.mu.Unlock()
+
+		if loaded {
+			room.close()
+			slog.Info("Room unloaded", "room", room.Name)
+		}
+	}()
+	return true
+}
+
+// close releases the room resources and disconnects its viewers
+func (r *Room) close() {
+	r.closePlaylist()
+	r.closePending()
+	r.closeSource()
+	r.broadcaster.Close()
+}
+
 // GetBroadcaster returns the broadcaster for a room
 func (r *Room) GetBroadcaster() *broadcaster.Broadcaster {
 	return r.broadcaster
 }
+
+// Settings returns the current settings of a room
+func (r *Room) Settings() Settings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.settings
+}
+
+// Authenticate checks the credentials of a room member or of an admin of its parent namespaces
+func (r *Room) Authenticate(username, password string) (bool, error) {
+	for _, a := range append([]*auth.Authenticator{r.auth}, r.inherited...) {
+		authenticated, err := a.Authenticate(username, password)
+		if err != nil || authenticated {
+			return authenticated, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,108 @@
+package room
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ncarlier/imgcast/internal/auth"
+	"github.com/ncarlier/imgcast/internal/storage"
+)
+
+// newTestManager creates a room manager storing its rooms in a temporary directory
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	store := storage.New(dir)
+	if err := store.EnsureBaseDir(); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(store, auth.NewAuthenticator(filepath.Join(dir, storage.HtpasswdFilename)), false, nil)
+	t.Cleanup(func() {
+		m.mu.Lock()
+		names := make([]string, 0, len(m.rooms))
+		for name := range m.rooms {
+			names = append(names, name)
+		}
+		m.mu.Unlock()
+		for _, name := range names {
+			m.unloadRoom(name)
+		}
+	})
+	return m
+}
+
+// newTestRoom creates a room with settings
+func newTestRoom(t *testing.T, m *Manager, name string, settings Settings) *Room {
+	t.Helper()
+	room, err := m.ProvisionRoom(name, Options{Settings: settings, Members: []Member{{Username: "alice", Password: "secret"}}})
+	if err != nil {
+		t.Fatalf("ProvisionRoom: %v", err)
+	}
+	return room
+}
+
+// testPNG returns a small PNG image of a color
+func testPNG(t *testing.T, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := range 4 {
+		for y := range 4 {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProvisionRoomInvalidMember(t *testing.T) {
+	m := newTestManager(t)
+
+	for _, username := range []string{"", "bob:admin", "bob\nadmin"} {
+		_, err := m.ProvisionRoom("lobby", Options{Members: []Member{{Username: "alice", Password: "secret"}, {Username: username, Password: "x"}}})
+		if !errors.Is(err, auth.ErrInvalidUsername) {
+			t.Errorf("ProvisionRoom with member %q = %v, want ErrInvalidUsername", username, err)
+		}
+		if m.storage.RoomExists("lobby") {
+			t.Fatalf("room left behind by a member %q", username)
+		}
+	}
+
+	if _, err := m.ProvisionRoom("lobby", Options{Members: []Member{{Username: "alice", Password: "secret"}}}); err != nil {
+		t.Fatalf("ProvisionRoom: %v", err)
+	}
+}
+
+func TestCheckRoomNames(t *testing.T) {
+	m := newTestManager(t)
+	newTestRoom(t, m, "lobby", Settings{})
+	if err := m.CheckRoomNames(); err != nil {
+		t.Fatalf("CheckRoomNames = %v, want nil", err)
+	}
+
+	// Rooms created by earlier versions under names that are no longer valid
+	for _, roomName := range []string{"live", "acme/settings/lobby", "_hidden"} {
+		dir := filepath.Join(m.storage.GetNamespaceDir(""), filepath.FromSlash(roomName))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, storage.HtpasswdFilename), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := m.CheckRoomNames()
+	if !errors.Is(err, ErrUnreachableRooms) {
+		t.Fatalf("CheckRoomNames = %v, want ErrUnreachableRooms", err)
+	}
+	if want := ErrUnreachableRooms.Error() + ": _hidden, acme/settings/lobby, live"; err.Error() != want {
+		t.Errorf("CheckRoomNames = %v, want %s", err, want)
+	}
+}
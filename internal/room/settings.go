@@ -0,0 +1,196 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+const (
+	// maxTitleLength is the maximum length of a room title
+	maxTitleLength = 128
+	// maxDescriptionLength is the maximum length of a room description
+	maxDescriptionLength = 1024
+	// maxGridColumns is the maximum number of columns of the grid layout
+	maxGridColumns = 16
+	// maxGridSlots is the maximum number of slots displayed by the grid layout
+	maxGridSlots = 64
+	// maxOverlayTextLength is the maximum length of the text overlay template
+	maxOverlayTextLength = 256
+	// maxOverlayTextSize is the maximum font size of the text overlay, in pixels
+	maxOverlayTextSize = 512
+	// maxSimilarityThreshold is the maximum distance between the perceptual hashes of similar images
+	maxSimilarityThreshold = 64
+	// maxSourceURLLength is the maximum length of the source URL
+	maxSourceURLLength = 2048
+)
+
+// ErrInvalidSettings is the error wrapped by all settings validation errors
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// FitModes are the supported ways of fitting the image into the viewer (empty means "scale-down")
+var FitModes = []string{"scale-down", "contain", "cover", "fill", "none"}
+
+// Layouts are the supported viewer layouts (empty means "single")
+var Layouts = []string{"single", "grid"}
+
+// colorRegex matches hexadecimal and named CSS colors
+var colorRegex = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// Settings holds the per-room settings persisted alongside the room data
+type Settings struct {
+	Title               string     `json:"title,omitempty"`
+	Description         string     `json:"description,omitempty"`
+	Background          string     `json:"background,omitempty"`
+	Fit                 string     `json:"fit,omitempty"`
+	HideStatus          bool       `json:"hide_status,omitempty"`
+	Layout              string     `json:"layout,omitempty"`
+	GridColumns         int        `json:"grid_columns,omitempty"`
+	GridSlots           []string   `json:"grid_slots,omitempty"`
+	Private             bool       `json:"private,omitempty"`
+	AutoOrient          bool       `json:"auto_orient,omitempty"`
+	StripMetadata       bool       `json:"strip_metadata,omitempty"`
+	WatermarkPosition   string     `json:"watermark_position,omitempty"`
+	WatermarkOpacity    float64    `json:"watermark_opacity,omitempty"`
+	WatermarkScale      float64    `json:"watermark_scale,omitempty"`
+	OverlayText         string     `json:"overlay_text,omitempty"`
+	OverlayTextPosition string     `json:"overlay_text_position,omitempty"`
+	OverlayTextColor    string     `json:"overlay_text_color,omitempty"`
+	OverlayTextSize     float64    `json:"overlay_text_size,omitempty"`
+	SkipDuplicates      bool       `json:"skip_duplicates,omitempty"`
+	SimilarityThreshold int        `json:"similarity_threshold,omitempty"`
+	SourceURL           string     `json:"source_url,omitempty"`
+	SourceInterval      int        `json:"source_interval,omitempty"`
+	HistorySize         int        `json:"history_size,omitempty"`
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+}
+
+// Public returns the settings shown to the viewers of a room, without the source configuration whose URL may
+// hold credentials
+func (s Settings) Public() Settings {
+	s.SourceURL, s.SourceInterval = "", 0
+	return s
+}
+
+// Validate checks that the settings values are acceptable
+func (s Settings) Validate() error {
+	if len(s.Title) > maxTitleLength {
+		return fmt.Errorf("%w: title must be at most %d characters", ErrInvalidSettings, maxTitleLength)
+	}
+	if len(s.Description) > maxDescriptionLength {
+		return fmt.Errorf("%w: description must be at most %d characters", ErrInvalidSettings, maxDescriptionLength)
+	}
+	if s.Background != "" && !colorRegex.MatchString(s.Background) {
+		return fmt.Errorf("%w: background must be a hexadecimal or named CSS color", ErrInvalidSettings)
+	}
+	if s.Fit != "" && !slices.Contains(FitModes, s.Fit) {
+		return fmt.Errorf("%w: fit must be one of %v", ErrInvalidSettings, FitModes)
+	}
+	if s.Layout != "" && !slices.Contains(Layouts, s.Layout) {
+		return fmt.Errorf("%w: layout must be one of %v", ErrInvalidSettings, Layouts)
+	}
+	if s.GridColumns < 0 || s.GridColumns > maxGridColumns {
+		return fmt.Errorf("%w: grid columns must be between 0 and %d", ErrInvalidSettings, maxGridColumns)
+	}
+	if len(s.GridSlots) > maxGridSlots {
+		return fmt.Errorf("%w: grid must have at most %d slots", ErrInvalidSettings, maxGridSlots)
+	}
+	for _, slot := range s.GridSlots {
+		if err := validator.ValidateSlotName(slot); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSettings, err)
+		}
+	}
+	for _, position := range []string{s.WatermarkPosition, s.OverlayTextPosition} {
+		if position != "" && !slices.Contains(imaging.Positions, position) {
+			return fmt.Errorf("%w: overlay position must be one of %v", ErrInvalidSettings, imaging.Positions)
+		}
+	}
+	if s.WatermarkOpacity < 0 || s.WatermarkOpacity > 1 {
+		return fmt.Errorf("%w: watermark opacity must be between 0 and 1", ErrInvalidSettings)
+	}
+	if s.WatermarkScale < 0 || s.WatermarkScale > 1 {
+		return fmt.Errorf("%w: watermark scale must be between 0 and 1", ErrInvalidSettings)
+	}
+	if len(s.OverlayText) > maxOverlayTextLength {
+		return fmt.Errorf("%w: overlay text must be at most %d characters", ErrInvalidSettings, maxOverlayTextLength)
+	}
+	if _, err := renderOverlayText(s.OverlayText, overlayData{}); err != nil {
+		return fmt.Errorf("%w: overlay text: %w", ErrInvalidSettings, err)
+	}
+	if s.OverlayTextColor != "" {
+		if _, err := imaging.ParseColor(s.OverlayTextColor); err != nil {
+			return fmt.Errorf("%w: overlay text color: %w", ErrInvalidSettings, err)
+		}
+	}
+	if s.OverlayTextSize < 0 || s.OverlayTextSize > maxOverlayTextSize {
+		return fmt.Errorf("%w: overlay text size must be between 0 and %d", ErrInvalidSettings, maxOverlayTextSize)
+	}
+	if s.SimilarityThreshold < 0 || s.SimilarityThreshold > maxSimilarityThreshold {
+		return fmt.Errorf("%w: similarity threshold must be between 0 and %d", ErrInvalidSettings, maxSimilarityThreshold)
+	}
+	if s.SourceURL != "" {
+		u, err := url.Parse(s.SourceURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(s.SourceURL) > maxSourceURLLength {
+			return fmt.Errorf("%w: source URL must be an http or https URL of at most %d characters", ErrInvalidSettings, maxSourceURLLength)
+		}
+	}
+	if s.SourceInterval != 0 && (s.SourceInterval < minSourceInterval || s.SourceInterval > maxSourceInterval) {
+		return fmt.Errorf("%w: source interval must be between %d and %d seconds", ErrInvalidSettings, minSourceInterval, maxSourceInterval)
+	}
+	if s.HistorySize < 0 || s.HistorySize > maxHistorySize {
+		return fmt.Errorf("%w: history size must be between 0 and %d", ErrInvalidSettings, maxHistorySize)
+	}
+	return nil
+}
+
+// loadSettings reads room settings from disk, returning defaults if the file does not exist
+func loadSettings(path string) (Settings, error) {
+	var settings Settings
+	err := loadJSON(path, &settings)
+	return settings, err
+}
+
+// saveSettings writes room settings to disk
+func saveSettings(path string, settings Settings) error {
+	return saveJSON(path, settings)
+}
+
+// loadJSON decodes a JSON file into v, leaving v untouched if the file does not exist
+func loadJSON(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
+
+// saveJSON encodes v into a JSON file
+func saveJSON(path string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(path), err)
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package room
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/fetch"
+)
+
+const (
+	// DefaultSourceInterval is the interval between two pulls of a source without explicit interval
+	DefaultSourceInterval = time.Minute
+	// minSourceInterval and maxSourceInterval bound the interval between two pulls of a source, in seconds
+	minSourceInterval = 5
+	maxSourceInterval = 24 * 60 * 60
+	// maxSourceBackoff is the maximum delay between two pulls of a failing source, unless its interval is longer
+	maxSourceBackoff = time.Hour
+)
+
+// ErrNoSource is returned when requesting the source status of a room without source
+var ErrNoSource = errors.New("room has no source")
+
+// SourceStatus describes the pulls of the source of a room
+type SourceStatus struct {
+	URL string `json:"url"`
+	// Interval is the interval between two pulls, in seconds
+	Interval   int        `json:"interval"`
+	LastCheck  *time.Time `json:"last_check,omitempty"`
+	LastChange *time.Time `json:"last_change,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	// Failures is the number of consecutive failed pulls, which delay the next ones
+	Failures  int        `json:"failures"`
+	NextCheck *time.Time `json:"next_check,omitempty"`
+}
+
+// sourceState holds the source status of a loaded room and its scheduling state
+type sourceState struct {
+	status SourceStatus
+	// hash is the SHA-256 of the last content pulled
+	hash  string
+	timer *time.Timer
+	// cancel aborts the pull in progress, if any
+	cancel context.CancelFunc
+	// generation is incremented when the source changes, so that outdated pulls are discarded
+	generation int
+	closed     bool
+}
+
+// StartSources enables the periodic pulls of the room sources, with the fetcher of the manager
+// The rooms having a source are loaded so that their source is pulled right away.
+func (m *Manager) StartSources() {
+	m.mu.Lock()
+	m.sourcesStarted = true
+	fetcher := m.fetcher
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	m.mu.Unlock()
+
+	// Rooms loaded before sources were started are armed here, the others when loaded
+	for _, room := range rooms {
+		m.armSource(room, fetcher)
+	}
+
+	roomNames, err := m.storage.ListRooms()
+	if err != nil {
+		slog.Error("Unable to start room sources", "error", err)
+		return
+	}
+	for _, roomName := range roomNames {
+		settings, err := loadSettings(m.storage.GetRoomSettingsPath(roomName))
+		if err != nil || settings.SourceURL == "" {
+			continue
+		}
+		if _, err := m.GetRoom(roomName); err != nil {
+			slog.Error("Unable to start room source", "room", roomName, "error", err)
+			continue
+		}
+		slog.Info("Room source started", "room", roomName, "url", settings.SourceURL)
+	}
+}
+
+// sourceFetcher returns the fetcher pulling the room sources, nil until sources are started
+// The manager lock must be held.
+func (m *Manager) sourceFetcher() *fetch.Fetcher {
+	if !m.sourcesStarted {
+		return nil
+	}
+	return m.fetcher
+}
+
+// SourceStatus returns the status of the source of a room
+func (m *Manager) SourceStatus(roomName string) (SourceStatus, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return SourceStatus{}, err
+	}
+
+	room.sourceMu.Lock()
+	defer room.sourceMu.Unlock()
+
+	if room.source.status.URL == "" {
+		return SourceStatus{}, ErrNoSource
+	}
+	return room.source.status, nil
+}
+
+// armSource (re)starts the pulls of the source of a room according to its settings, resetting its status
+// Sources are not pulled without fetcher.
+func (m *Manager) armSource(room *Room, fetcher *fetch.Fetcher) {
+	settings := room.Settings()
+
+	room.sourceMu.Lock()
+	defer room.sourceMu.Unlock()
+
+	state := &room.source
+	state.stop()
+	state.generation++
+	state.status = SourceStatus{}
+	state.hash = ""
+	if fetcher == nil || settings.SourceURL == "" || state.closed {
+		return
+	}
+
+	state.status = SourceStatus{URL: settings.SourceURL, Interval: int(sourceInterval(settings).Seconds())}
+	m.scheduleSource(room, fetcher, 0)
+}
+
+// scheduleSource arms the timer of the next pull of the source of a room
+// The source lock must be held.
+func (m *Manager) scheduleSource(room *Room, fetcher *fetch.Fetcher, delay time.Duration) {
+	state := &room.source
+	generation := state.generation
+	next := time.Now().Add(delay).UTC()
+	state.status.NextCheck = &next
+	state.timer = time.AfterFunc(delay, func() {
+		m.pullSource(room, fetcher, generation)
+	})
+}
+
+// pullSource fetches the source of a room, publishes its content if it changed and schedules the next pull
+func (m *Manager) pullSource(room *Room, fetcher *fetch.Fetcher, generation int) {
+	room.sourceMu.Lock()
+	state := &room.source
+	if state.closed || state.generation != generation || m.roomDeleted(room) {
+		room.sourceMu.Unlock()
+		return
+	}
+	url, hash := state.status.URL, state.hash
+	ctx, cancel := context.WithCancel(context.Background())
+	state.cancel = cancel
+	room.sourceMu.Unlock()
+	defer cancel()
+
+	changed, hash, err := m.fetchSource(ctx, room, fetcher, url, hash)
+
+	room.sourceMu.Lock()
+	defer room.sourceMu.Unlock()
+	if state.closed || state.generation != generation {
+		return
+	}
+	state.cancel = nil
+
+	now := time.Now().UTC()
+	state.status.LastCheck = &now
+	interval := time.Duration(state.status.Interval) * time.Second
+	if err != nil {
+		state.status.Failures++
+		state.status.LastError = err.Error()
+		slog.Warn("Unable to pull room source", "room", room.Name, "url", url, "failures", state.status.Failures, "error", err)
+		m.scheduleSource(room, fetcher, sourceBackoff(interval, state.status.Failures))
+		return
+	}
+
+	state.status.Failures = 0
+	state.status.LastError = ""
+	state.hash = hash
+	if changed {
+		state.status.LastChange = &now
+		slog.Info("Room source published", "room", room.Name, "url", url)
+	}
+	m.scheduleSource(room, fetcher, interval)
+}
+
+// fetchSource fetches the source of a room and publishes its content unless it matches the previous one
+// The previous content is identified by its hash, or by the version of the current image when unknown.
+func (m *Manager) fetchSource(ctx context.Context, room *Room, fetcher *fetch.Fetcher, url, previous string) (bool, string, error) {
+	body, err := fetcher.Fetch(ctx, url)
+	if err != nil {
+		return false, "", err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return false, "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if previous == "" {
+		if version, err := m.loadVersion(room, ""); err == nil && version != nil {
+			previous = version.SHA256
+		}
+	}
+	if hash == previous {
+		return false, hash, nil
+	}
+
+	published, err := m.Publish(room, "", bytes.NewReader(data))
+	if err != nil {
+		return false, "", fmt.Errorf("failed to publish source image: %w", err)
+	}
+	return published, hash, nil
+}
+
+// stop stops the timer and the pull in progress of a source
+// The source lock must be held.
+func (state *sourceState) stop() {
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+	if state.cancel != nil {
+		state.cancel()
+		state.cancel = nil
+	}
+}
+
+// closeSource stops the pulls of the source of an unloaded room
+func (room *Room) closeSource() {
+	room.sourceMu.Lock()
+	defer room.sourceMu.Unlock()
+
+	room.source.closed = true
+	room.source.stop()
+}
+
+// sourceInterval returns the interval between two pulls of the source of a room
+func sourceInterval(settings Settings) time.Duration {
+	if settings.SourceInterval <= 0 {
+		return DefaultSourceInterval
+	}
+	return time.Duration(settings.SourceInterval) * time.Second
+}
+
+// sourceBackoff returns the delay before pulling a source again after consecutive failures
+// The interval doubles with each failure, up to maxSourceBackoff or the interval if longer.
+func sourceBackoff(interval time.Duration, failures int) time.Duration {
+	limit := max(interval, maxSourceBackoff)
+	delay := interval
+	for i := 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
@@ -0,0 +1,177 @@
+package room
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/fetch"
+)
+
+// sourceStandIn is a local image server standing in for a room source
+type sourceStandIn struct {
+	mu       sync.Mutex
+	image    []byte
+	status   int
+	requests int
+}
+
+// ServeHTTP answers with the current image or status
+func (s *sourceStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.status != http.StatusOK {
+		w.WriteHeader(s.status)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(s.image)
+}
+
+// set changes the answer of the stand-in
+func (s *sourceStandIn) set(image []byte, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.image, s.status = image, status
+}
+
+// pull pulls the source of a room synchronously, as its timer would
+func pull(m *Manager, room *Room) SourceStatus {
+	room.sourceMu.Lock()
+	generation := room.source.generation
+	room.source.stop()
+	room.sourceMu.Unlock()
+
+	m.pullSource(room, m.fetcher, generation)
+
+	room.sourceMu.Lock()
+	defer room.sourceMu.Unlock()
+	return room.source.status
+}
+
+func TestSourcePull(t *testing.T) {
+	standIn := &sourceStandIn{}
+	standIn.set(testPNG(t, color.White), http.StatusOK)
+	srv := httptest.NewServer(standIn)
+	t.Cleanup(srv.Close)
+
+	m := newTestManager(t)
+	m.SetFetcher(fetch.New(fetch.Options{Timeout: 5 * time.Second, AllowPrivate: true}))
+	room := newTestRoom(t, m, "lobby", Settings{SourceURL: srv.URL, SourceInterval: 60})
+
+	// Starting the sources pulls them right away
+	m.StartSources()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := m.SourceStatus("lobby")
+		if err != nil {
+			t.Fatalf("SourceStatus: %v", err)
+		}
+		if status.LastChange != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("source not published: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !m.storage.HasImage("lobby", "") {
+		t.Fatal("source image not stored")
+	}
+	first, err := m.loadVersion(room, "")
+	if err != nil || first == nil {
+		t.Fatalf("loadVersion: %v", err)
+	}
+
+	// Unchanged content is not published again
+	status := pull(m, room)
+	if status.Failures != 0 || status.LastCheck.Before(*status.LastChange) {
+		t.Errorf("unchanged source: %+v", status)
+	}
+	if version, _ := m.loadVersion(room, ""); version.SHA256 != first.SHA256 {
+		t.Error("unchanged source published again")
+	}
+
+	// Changed content is published
+	standIn.set(testPNG(t, color.Black), http.StatusOK)
+	pull(m, room)
+	if version, _ := m.loadVersion(room, ""); version.SHA256 == first.SHA256 {
+		t.Error("changed source not published")
+	}
+}
+
+func TestSourceBackoff(t *testing.T) {
+	standIn := &sourceStandIn{}
+	standIn.set(nil, http.StatusServiceUnavailable)
+	srv := httptest.NewServer(standIn)
+	t.Cleanup(srv.Close)
+
+	m := newTestManager(t)
+	m.SetFetcher(fetch.New(fetch.Options{Timeout: 5 * time.Second, AllowPrivate: true}))
+	room := newTestRoom(t, m, "lobby", Settings{SourceURL: srv.URL, SourceInterval: 60})
+	room.sourceMu.Lock()
+	room.source.status = SourceStatus{URL: srv.URL, Interval: 60}
+	room.sourceMu.Unlock()
+
+	// Each failure doubles the delay before the next pull
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		status := pull(m, room)
+		if status.Failures != i+1 || !strings.Contains(status.LastError, "503") {
+			t.Fatalf("pull %d: %+v", i+1, status)
+		}
+		if delay := status.NextCheck.Sub(*status.LastCheck); delay < want-time.Second || delay > want+time.Second {
+			t.Errorf("pull %d: next check in %s, want %s", i+1, delay, want)
+		}
+	}
+
+	// A successful pull resets the failures
+	standIn.set(testPNG(t, color.White), http.StatusOK)
+	if status := pull(m, room); status.Failures != 0 || status.LastError != "" {
+		t.Errorf("recovered source: %+v", status)
+	}
+}
+
+func TestSourceRefusesPrivateAddresses(t *testing.T) {
+	standIn := &sourceStandIn{}
+	standIn.set(testPNG(t, color.White), http.StatusOK)
+	srv := httptest.NewServer(standIn)
+	t.Cleanup(srv.Close)
+
+	m := newTestManager(t)
+	m.SetFetcher(fetch.New(fetch.Options{Timeout: 5 * time.Second}))
+	room := newTestRoom(t, m, "lobby", Settings{SourceURL: srv.URL})
+	room.sourceMu.Lock()
+	room.source.status = SourceStatus{URL: srv.URL, Interval: 60}
+	room.sourceMu.Unlock()
+
+	status := pull(m, room)
+	if status.Failures != 1 || !strings.Contains(status.LastError, fetch.ErrForbiddenAddress.Error()) {
+		t.Errorf("private source: %+v", status)
+	}
+	if standIn.requests != 0 {
+		t.Errorf("private source requested %d times", standIn.requests)
+	}
+}
+
+func TestSourceBackoffDelay(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{interval: time.Minute, failures: 1, want: time.Minute},
+		{interval: time.Minute, failures: 3, want: 4 * time.Minute},
+		{interval: time.Minute, failures: 20, want: maxSourceBackoff},
+		{interval: 2 * time.Hour, failures: 5, want: 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := sourceBackoff(tt.interval, tt.failures); got != tt.want {
+			t.Errorf("sourceBackoff(%s, %d) = %s, want %s", tt.interval, tt.failures, got, tt.want)
+		}
+	}
+}
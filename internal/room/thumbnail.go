@@ -0,0 +1,76 @@
+package room
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+// ErrNoThumbnail is returned when no thumbnail can be provided for the image of a room slot
+var ErrNoThumbnail = errors.New("no thumbnail available")
+
+// Thumbnail returns the path to the thumbnail of the image of a room slot best matching a size
+// The smallest thumbnail at least as large as the size is picked, or the largest one; a zero size picks
+// the smallest thumbnail. Missing thumbnails, of images published before their size was configured, are
+// generated on demand.
+func (m *Manager) Thumbnail(room *Room, slot string, size int) (string, error) {
+	if len(m.thumbnailSizes) == 0 || !m.storage.HasImage(room.Name, slot) {
+		return "", ErrNoThumbnail
+	}
+
+	thumbnailSize := m.thumbnailSizes[len(m.thumbnailSizes)-1]
+	for _, s := range m.thumbnailSizes {
+		if s >= size {
+			thumbnailSize = s
+			break
+		}
+	}
+
+	path := m.storage.GetThumbnailPath(room.Name, slot, thumbnailSize)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := m.generateThumbnails(room, slot); err != nil {
+		if errors.Is(err, imaging.ErrUnsupportedImage) {
+			return "", ErrNoThumbnail
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// generateThumbnails generates the thumbnails of all the configured sizes for the image of a room slot
+func (m *Manager) generateThumbnails(room *Room, slot string) error {
+	if len(m.thumbnailSizes) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(m.storage.GetSlotImagePath(room.Name, slot))
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	img, err := imaging.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	for _, size := range m.thumbnailSizes {
+		var thumbnail bytes.Buffer
+		resized := imaging.Resize(img, imaging.Options{Width: size, Height: size})
+		if err := imaging.Encode(&thumbnail, resized, "jpeg"); err != nil {
+			return err
+		}
+		if err := m.storage.SaveThumbnail(room.Name, slot, size, &thumbnail); err != nil {
+			return err
+		}
+	}
+
+	slog.Debug("Thumbnails generated", "room", room.Name, "slot", slot, "sizes", m.thumbnailSizes)
+	return nil
+}
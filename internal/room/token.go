@@ -0,0 +1,166 @@
+package room
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const (
+	// maxTokens is the maximum number of ingestion tokens of a room
+	maxTokens = 20
+	// maxTokenLabelLength is the maximum length of an ingestion token label
+	maxTokenLabelLength = 128
+	// tokenSize is the number of random bytes of an ingestion token
+	tokenSize = 12
+)
+
+var (
+	// ErrTokenNotFound is returned when referencing an unknown ingestion token
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokensFull is returned when creating an ingestion token in a room having too many of them
+	ErrTokensFull = errors.New("too many tokens")
+	// ErrInvalidToken is returned when creating an ingestion token with an invalid label
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Token is an ingestion token, allowing to publish to a room from channels without Basic Auth (such as email)
+// The token value is only known when created: its hash is stored.
+type Token struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// storedToken is an ingestion token as persisted
+type storedToken struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+// CreateToken creates an ingestion token for a room, returning it along with its value
+func (m *Manager) CreateToken(roomName, label string) (Token, string, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return Token{}, "", err
+	}
+	if len(label) > maxTokenLabelLength {
+		return Token{}, "", fmt.Errorf("%w: label must be at most %d characters", ErrInvalidToken, maxTokenLabelLength)
+	}
+
+	m.tokensMu.Lock()
+	defer m.tokensMu.Unlock()
+
+	tokens, err := m.loadTokens(room.Name)
+	if err != nil {
+		return Token{}, "", err
+	}
+	if len(tokens) >= maxTokens {
+		return Token{}, "", fmt.Errorf("%w: at most %d", ErrTokensFull, maxTokens)
+	}
+
+	secret := make([]byte, tokenSize)
+	rand.Read(secret)
+	value := hex.EncodeToString(secret)
+	token := storedToken{
+		Token: Token{ID: newItemID(), Label: label, CreatedAt: time.Now().UTC()},
+		Hash:  hashToken(value),
+	}
+	if err := saveJSON(m.storage.GetRoomTokensPath(room.Name), append(tokens, token)); err != nil {
+		return Token{}, "", err
+	}
+
+	slog.Info("Ingestion token created", "room", room.Name, "token", token.ID)
+	return token.Token, value, nil
+}
+
+// ListTokens returns the ingestion tokens of a room, without their value
+func (m *Manager) ListTokens(roomName string) ([]Token, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.tokensMu.Lock()
+	stored, err := m.loadTokens(room.Name)
+	m.tokensMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]Token, 0, len(stored))
+	for _, token := range stored {
+		tokens = append(tokens, token.Token)
+	}
+	return tokens, nil
+}
+
+// DeleteToken revokes an ingestion token of a room
+func (m *Manager) DeleteToken(roomName, id string) error {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return err
+	}
+
+	m.tokensMu.Lock()
+	defer m.tokensMu.Unlock()
+
+	tokens, err := m.loadTokens(room.Name)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(tokens, func(t storedToken) bool { return t.ID == id })
+	if index < 0 {
+		return ErrTokenNotFound
+	}
+	if err := saveJSON(m.storage.GetRoomTokensPath(room.Name), slices.Delete(tokens, index, index+1)); err != nil {
+		return err
+	}
+
+	slog.Info("Ingestion token deleted", "room", room.Name, "token", id)
+	return nil
+}
+
+// AuthenticateToken checks an ingestion token of a room
+func (m *Manager) AuthenticateToken(roomName, value string) (bool, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return false, err
+	}
+
+	m.tokensMu.Lock()
+	tokens, err := m.loadTokens(room.Name)
+	m.tokensMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	hash := []byte(hashToken(value))
+	authenticated := false
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 {
+			authenticated = true
+		}
+	}
+	return authenticated, nil
+}
+
+// loadTokens reads the ingestion tokens of a room
+// The tokens lock must be held.
+func (m *Manager) loadTokens(roomName string) ([]storedToken, error) {
+	tokens := []storedToken{}
+	err := loadJSON(m.storage.GetRoomTokensPath(roomName), &tokens)
+	return tokens, err
+}
+
+// hashToken returns the hash under which an ingestion token is stored
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,47 @@
+package room
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/imaging"
+)
+
+// Version describes the current image of a room slot
+type Version struct {
+	// SHA256 is the hash of the image as uploaded
+	SHA256 string `json:"sha256"`
+	// DHash is the perceptual hash of the image, computed when the room detects similar uploads
+	DHash       string    `json:"dhash,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// loadVersion returns the version of the current image of a room slot, or nil if unknown
+func (m *Manager) loadVersion(room *Room, slot string) (*Version, error) {
+	var version *Version
+	err := loadJSON(m.storage.GetVersionPath(room.Name, slot), &version)
+	return version, err
+}
+
+// saveVersion records the version of the current image of a room slot
+func (m *Manager) saveVersion(room *Room, slot string, version Version) error {
+	return saveJSON(m.storage.GetVersionPath(room.Name, slot), version)
+}
+
+// isDuplicate reports whether an upload matches the previous version of the image, according to the room settings
+// Uploads are duplicates when identical, or when their perceptual hashes differ by at most the similarity threshold.
+func isDuplicate(settings Settings, previous *Version, current Version) bool {
+	if !settings.SkipDuplicates || previous == nil {
+		return false
+	}
+	if previous.SHA256 == current.SHA256 {
+		return true
+	}
+	if settings.SimilarityThreshold == 0 || previous.DHash == "" || current.DHash == "" {
+		return false
+	}
+
+	a, errA := strconv.ParseUint(previous.DHash, 16, 64)
+	b, errB := strconv.ParseUint(current.DHash, 16, 64)
+	return errA == nil && errB == nil && imaging.HashDistance(a, b) <= settings.SimilarityThreshold
+}
@@ -0,0 +1,378 @@
+package room
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/fetch"
+)
+
+// Webhook event types
+const (
+	EventImagePublished = "image.published"
+	EventRoomCreated    = "room.created"
+	EventRoomDeleted    = "room.deleted"
+)
+
+const (
+	// maxWebhooks is the maximum number of webhook subscriptions of a room
+	maxWebhooks = 20
+	// maxWebhookDeliveries is the number of deliveries kept in the delivery log of a room
+	maxWebhookDeliveries = 100
+	// maxWebhookAttempts is the number of attempts to deliver an event before giving up
+	maxWebhookAttempts = 5
+	// webhookRetryDelay is the delay before the first retry of a delivery, doubled at each retry
+	webhookRetryDelay = 2 * time.Second
+	// maxWebhookURLLength is the maximum length of a webhook URL
+	maxWebhookURLLength = 2048
+	// SignatureHeader is the header holding the HMAC-SHA256 signature of a webhook payload
+	SignatureHeader = "X-Imgcast-Signature"
+)
+
+// EventTypes are the event types webhooks can subscribe to
+var EventTypes = []string{EventImagePublished, EventRoomCreated, EventRoomDeleted}
+
+var (
+	// ErrWebhookNotFound is returned when referencing an unknown webhook
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrWebhooksFull is returned when subscribing a webhook to a room having too many of them
+	ErrWebhooksFull = errors.New("too many webhooks")
+	// ErrInvalidWebhook is the error wrapped by all webhook validation errors
+	ErrInvalidWebhook = errors.New("invalid webhook")
+)
+
+// Webhook is a subscription of an external URL to the events of a room
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events are the subscribed event types (empty means all)
+	Events []string `json:"events,omitempty"`
+	// Secret is the key of the payload signatures, only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event is an event of a room, as posted to webhooks
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Room      string    `json:"room"`
+	Slot      string    `json:"slot,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Delivery is an entry of the webhook delivery log of a room
+type Delivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	Event     Event  `json:"event"`
+	Attempts  int    `json:"attempts"`
+	// StatusCode is the status of the last response, if any
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Validate checks that a webhook subscription is acceptable
+func (w Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(w.URL) > maxWebhookURLLength {
+		return fmt.Errorf("%w: URL must be an http or https URL of at most %d characters", ErrInvalidWebhook, maxWebhookURLLength)
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(EventTypes, event) {
+			return fmt.Errorf("%w: events must be among %v", ErrInvalidWebhook, EventTypes)
+		}
+	}
+	return nil
+}
+
+// ListWebhooks returns the webhook subscriptions of a room, without their secret
+func (m *Manager) ListWebhooks(roomName string) ([]Webhook, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.webhooksMu.Lock()
+	webhooks, err := m.loadWebhooks(room.Name)
+	m.webhooksMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// AddWebhook subscribes a webhook to the events of a room
+// A secret is generated unless provided.
+func (m *Manager) AddWebhook(roomName string, webhook Webhook) (Webhook, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	m.webhooksMu.Lock()
+	defer m.webhooksMu.Unlock()
+
+	webhooks, err := m.loadWebhooks(room.Name)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(webhooks) >= maxWebhooks {
+		return Webhook{}, fmt.Errorf("%w: at most %d", ErrWebhooksFull, maxWebhooks)
+	}
+
+	webhook, err = newWebhook(webhook)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if err := saveJSON(m.storage.GetRoomWebhooksPath(room.Name), append(webhooks, webhook)); err != nil {
+		return Webhook{}, err
+	}
+
+	slog.Info("Webhook added", "room", room.Name, "webhook", webhook.ID)
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook subscription of a room, along with its deliveries
+func (m *Manager) DeleteWebhook(roomName, id string) error {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return err
+	}
+
+	m.webhooksMu.Lock()
+	defer m.webhooksMu.Unlock()
+
+	webhooks, err := m.loadWebhooks(room.Name)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(webhooks, func(w Webhook) bool { return w.ID == id })
+	if index < 0 {
+		return ErrWebhookNotFound
+	}
+	if err := saveJSON(m.storage.GetRoomWebhooksPath(room.Name), slices.Delete(webhooks, index, index+1)); err != nil {
+		return err
+	}
+
+	deliveries, err := m.loadDeliveries(room.Name)
+	if err == nil {
+		deliveries = slices.DeleteFunc(deliveries, func(d Delivery) bool { return d.WebhookID == id })
+		err = saveJSON(m.storage.GetRoomWebhookDeliveriesPath(room.Name), deliveries)
+	}
+	if err != nil {
+		slog.Warn("Unable to delete webhook deliveries", "room", room.Name, "webhook", id, "error", err)
+	}
+
+	slog.Info("Webhook deleted", "room", room.Name, "webhook", id)
+	return nil
+}
+
+// WebhookDeliveries returns the logged deliveries of a webhook of a room, most recent first
+func (m *Manager) WebhookDeliveries(roomName, id string) ([]Delivery, error) {
+	room, err := m.GetRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.webhooksMu.Lock()
+	defer m.webhooksMu.Unlock()
+
+	webhooks, err := m.loadWebhooks(room.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(webhooks, func(w Webhook) bool { return w.ID == id }) {
+		return nil, ErrWebhookNotFound
+	}
+
+	deliveries, err := m.loadDeliveries(room.Name)
+	if err != nil {
+		return nil, err
+	}
+	deliveries = slices.DeleteFunc(deliveries, func(d Delivery) bool { return d.WebhookID != id })
+	slices.Reverse(deliveries)
+	return deliveries, nil
+}
+
+// OnEvent registers a listener called with the events of all rooms
+// Listeners are called synchronously and must not block.
+func (m *Manager) OnEvent(listener func(Event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// notifyEvent delivers an event of a room to the event listeners and to its subscribed webhooks
+func (m *Manager) notifyEvent(roomName, eventType, slot string) {
+	m.webhooksMu.Lock()
+	webhooks, err := m.loadWebhooks(roomName)
+	m.webhooksMu.Unlock()
+	if err != nil {
+		slog.Warn("Unable to load webhooks", "room", roomName, "error", err)
+	}
+	m.dispatchEvent(roomName, webhooks, eventType, slot)
+}
+
+// dispatchEvent delivers an event of a room to the event listeners, and to the given webhooks subscribed to it
+// in the background
+func (m *Manager) dispatchEvent(roomName string, webhooks []Webhook, eventType, slot string) {
+	m.mu.RLock()
+	fetcher, listeners := m.webhookFetcher, m.listeners
+	m.mu.RUnlock()
+
+	event := Event{ID: newItemID(), Type: eventType, Room: roomName, Slot: slot, Timestamp: time.Now().UTC()}
+	for _, listener := range listeners {
+		listener(event)
+	}
+	if fetcher == nil || len(webhooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Unable to encode webhook event", "room", roomName, "event", eventType, "error", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, eventType) {
+			continue
+		}
+		delivery := Delivery{ID: newItemID(), WebhookID: webhook.ID, Event: event}
+		go m.deliverWebhook(fetcher, roomName, webhook, delivery, payload)
+	}
+}
+
+// deliverWebhook posts an event payload to a webhook, retrying with an exponential backoff until it succeeds
+// Each attempt is recorded in the delivery log of the room.
+func (m *Manager) deliverWebhook(fetcher *fetch.Fetcher, roomName string, webhook Webhook, delivery Delivery, payload []byte) {
+	delay := webhookRetryDelay
+	for delivery.Attempts < maxWebhookAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		delivery.Attempts++
+		delivery.StatusCode, delivery.Error = 0, ""
+		statusCode, err := postWebhook(fetcher, webhook, delivery, payload)
+		delivery.StatusCode = statusCode
+		delivery.Delivered = err == nil
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.UpdatedAt = time.Now().UTC()
+		m.recordDelivery(roomName, delivery)
+
+		if delivery.Delivered {
+			slog.Debug("Webhook delivered", "room", roomName, "webhook", webhook.ID, "event", delivery.Event.Type)
+			return
+		}
+		slog.Warn("Unable to deliver webhook", "room", roomName, "webhook", webhook.ID, "event", delivery.Event.Type,
+			"attempts", delivery.Attempts, "error", err)
+	}
+}
+
+// postWebhook sends a signed event payload to a webhook, returning the response status
+func postWebhook(fetcher *fetch.Fetcher, webhook Webhook, delivery Delivery, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write(payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "imgcast")
+	req.Header.Set("X-Imgcast-Event", delivery.Event.Type)
+	req.Header.Set("X-Imgcast-Delivery", delivery.ID)
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := fetcher.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// recordDelivery adds or updates a delivery in the delivery log of a room, keeping the most recent ones
+// Deliveries of deleted rooms are not recorded.
+func (m *Manager) recordDelivery(roomName string, delivery Delivery) {
+	m.webhooksMu.Lock()
+	defer m.webhooksMu.Unlock()
+
+	if !m.storage.RoomExists(roomName) {
+		return
+	}
+	deliveries, err := m.loadDeliveries(roomName)
+	if err == nil {
+		if index := slices.IndexFunc(deliveries, func(d Delivery) bool { return d.ID == delivery.ID }); index >= 0 {
+			deliveries[index] = delivery
+		} else {
+			deliveries = append(deliveries, delivery)
+		}
+		if len(deliveries) > maxWebhookDeliveries {
+			deliveries = deliveries[len(deliveries)-maxWebhookDeliveries:]
+		}
+		err = saveJSON(m.storage.GetRoomWebhookDeliveriesPath(roomName), deliveries)
+	}
+	if err != nil {
+		slog.Warn("Unable to record webhook delivery", "room", roomName, "webhook", delivery.WebhookID, "error", err)
+	}
+}
+
+// loadWebhooks reads the webhook subscriptions of a room
+// The webhooks lock must be held.
+func (m *Manager) loadWebhooks(roomName string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := loadJSON(m.storage.GetRoomWebhooksPath(roomName), &webhooks)
+	return webhooks, err
+}
+
+// loadDeliveries reads the webhook delivery log of a room, oldest first
+// The webhooks lock must be held.
+func (m *Manager) loadDeliveries(roomName string) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := loadJSON(m.storage.GetRoomWebhookDeliveriesPath(roomName), &deliveries)
+	return deliveries, err
+}
+
+// newWebhook validates a webhook subscription and sets its identifier, creation time and secret if missing
+func newWebhook(webhook Webhook) (Webhook, error) {
+	if err := webhook.Validate(); err != nil {
+		return Webhook{}, err
+	}
+	webhook.ID = newItemID()
+	webhook.CreatedAt = time.Now().UTC()
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	return webhook, nil
+}
@@ -0,0 +1,178 @@
+package smtpd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"github.com/ncarlier/imgcast/internal/hook"
+	"github.com/ncarlier/imgcast/internal/imaging"
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/pkg/validator"
+)
+
+// maxPartDepth is the maximum nesting of the multipart messages searched for an image
+const maxPartDepth = 5
+
+// errNoImage is returned when a message holds no image
+var errNoImage = errors.New("no image found")
+
+// recipient is an accepted recipient of a message
+type recipient struct {
+	room string
+}
+
+// recipient checks a recipient address of the form {room}+{token}@{domain}
+func (s *Server) recipient(address string) (recipient, error) {
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || !strings.EqualFold(domain, s.domain) {
+		return recipient{}, &reply{550, "5.7.1 Relaying denied"}
+	}
+	index := strings.LastIndex(local, "+")
+	if index < 0 {
+		return recipient{}, &reply{550, "5.7.1 Address must hold an ingestion token: {room}+{token}@" + s.domain}
+	}
+	roomName, token := validator.NormalizeRoomPath(local[:index]), local[index+1:]
+
+	authenticated, err := s.manager.AuthenticateToken(roomName, token)
+	if errors.Is(err, room.ErrRoomNotFound) || errors.Is(err, validator.ErrInvalidRoomName) {
+		return recipient{}, &reply{550, "5.1.1 No such room"}
+	}
+	if err != nil {
+		return recipient{}, err
+	}
+	if !authenticated {
+		return recipient{}, &reply{550, "5.7.1 Invalid ingestion token"}
+	}
+	return recipient{room: roomName}, nil
+}
+
+// deliver publishes the first image of a message to the rooms of its recipients
+// The message and the rooms of all recipients are checked before publishing anything. Once the image is published
+// to a room, failures in other rooms are only logged: refusing the message would have the sender retry it and
+// publish the image again.
+func (s *Server) deliver(recipients []recipient, from string, message []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return &reply{550, "5.6.0 Invalid message"}
+	}
+	data, err := findImage(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, 0)
+	if errors.Is(err, errNoImage) {
+		return &reply{550, "5.6.0 No image attachment found"}
+	}
+	if err != nil {
+		return &reply{550, "5.6.0 Invalid message: " + err.Error()}
+	}
+
+	rooms := make([]*room.Room, 0, len(recipients))
+	for _, recipient := range recipients {
+		rm, err := s.manager.GetRoom(recipient.room)
+		if errors.Is(err, room.ErrRoomNotFound) {
+			return &reply{550, "5.1.1 No such room"}
+		}
+		if err != nil {
+			slog.Error("Unable to load email recipient room", "room", recipient.room, "error", err)
+			return err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	var failure error
+	delivered := 0
+	for _, rm := range rooms {
+		if err := s.publish(rm, from, data); err != nil {
+			if failure == nil {
+				failure = err
+			}
+			continue
+		}
+		delivered++
+	}
+	if delivered == 0 {
+		return failure
+	}
+	return nil
+}
+
+// publish publishes an emailed image to a room
+func (s *Server) publish(rm *room.Room, from string, data []byte) error {
+	published, err := s.manager.Publish(rm, "", bytes.NewReader(data))
+	var rejected *hook.RejectedError
+	if errors.As(err, &rejected) {
+		slog.Info("Emailed image rejected", "room", rm.Name, "from", from, "reason", rejected.Reason)
+		return &reply{550, "5.7.1 " + rejected.Error()}
+	}
+	if err != nil {
+		slog.Error("Unable to publish emailed image", "room", rm.Name, "error", err)
+		return err
+	}
+
+	if published {
+		slog.Info("Image received by email", "room", rm.Name, "from", from)
+	} else {
+		slog.Info("Duplicate image skipped", "room", rm.Name, "from", from)
+	}
+	return nil
+}
+
+// findImage returns the first image of a message body or part, searching multipart contents depth-first
+// Images are parts with an image content type, or generic binary parts holding an image.
+func findImage(contentType, encoding string, body io.Reader, depth int) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if depth >= maxPartDepth || params["boundary"] == "" {
+			return nil, errNoImage
+		}
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil, errNoImage
+			}
+			if err != nil {
+				return nil, err
+			}
+			data, err := findImage(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if !errors.Is(err, errNoImage) {
+				return data, err
+			}
+		}
+
+	case strings.HasPrefix(mediaType, "image/"), mediaType == "application/octet-stream":
+		data, err := io.ReadAll(decode(body, encoding))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode attachment: %w", err)
+		}
+		if imaging.DetectFormat(data) == "" {
+			return nil, errNoImage
+		}
+		return data, nil
+	}
+
+	return nil, errNoImage
+}
+
+// decode returns the decoded content of a part according to its transfer encoding
+func decode(body io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
@@ -0,0 +1,283 @@
+// Package smtpd implements a minimal SMTP server publishing the images received by email to rooms
+// Mail sent to {room}+{token}@{domain} publishes its first image to the room, the token being an ingestion token
+// of the room.
+package smtpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ncarlier/imgcast/internal/room"
+)
+
+const (
+	// DefaultDomain is the mail domain of the rooms without explicit domain
+	DefaultDomain = "imgcast.local"
+	// commandTimeout is the maximum duration of a command, and of the idle time between commands
+	commandTimeout = 5 * time.Minute
+	// dataTimeout is the maximum duration of the transfer of a message
+	dataTimeout = 10 * time.Minute
+	// maxRecipients is the maximum number of recipients of a message
+	maxRecipients = 10
+	// maxConnections is the maximum number of simultaneous connections
+	maxConnections = 100
+	// messageOverhead is the size allowed for the headers and the encoding of a message, besides its image
+	messageOverhead = 1 << 20
+	// maxMessageSize is the maximum size of a message when the size of images is not limited
+	maxMessageSize = 128 << 20
+)
+
+// reply is an SMTP reply, also used as the error of a refused command
+type reply struct {
+	code    int
+	message string
+}
+
+// Error returns the reply line
+func (r *reply) Error() string {
+	return strconv.Itoa(r.code) + " " + r.message
+}
+
+// Server is an SMTP server receiving the images of rooms
+type Server struct {
+	manager *room.Manager
+	domain  string
+	// maxSize is the maximum size of a message, in bytes
+	maxSize     int64
+	connections atomic.Int32
+}
+
+// New creates an SMTP server accepting mail for the given domain, with images of at most maxImageSize bytes
+// (zero means no limit besides the maximum message size)
+func New(manager *room.Manager, domain string, maxImageSize int64) *Server {
+	if domain == "" {
+		domain = DefaultDomain
+	}
+	maxSize := int64(maxMessageSize)
+	if maxImageSize > 0 {
+		// Attachments are base64-encoded, taking 4 bytes for 3
+		maxSize = maxImageSize/3*4 + messageOverhead
+	}
+	return &Server{manager: manager, domain: strings.ToLower(domain), maxSize: maxSize}
+}
+
+// ListenAndServe listens on a TCP address and serves SMTP connections
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves the SMTP connections of a listener
+func (s *Server) Serve(listener net.Listener) error {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// session holds the state of an SMTP connection
+type session struct {
+	server     *Server
+	conn       net.Conn
+	text       *textproto.Conn
+	hello      bool
+	from       string
+	hasFrom    bool
+	recipients []recipient
+}
+
+// serveConn runs the SMTP dialog of a connection
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	if s.connections.Add(1) > maxConnections {
+		s.connections.Add(-1)
+		text.PrintfLine("421 4.3.2 Too many connections, try again later")
+		return
+	}
+	defer s.connections.Add(-1)
+
+	sess := &session{server: s, conn: conn, text: text}
+	sess.write(&reply{220, s.domain + " imgcast ESMTP ready"})
+	for {
+		conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := text.ReadLine()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Debug("SMTP connection closed", "remote", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		if verb == "QUIT" {
+			sess.write(&reply{221, "2.0.0 Bye"})
+			return
+		}
+		sess.write(sess.handle(verb, strings.TrimSpace(arg)))
+	}
+}
+
+// handle runs an SMTP command and returns its reply
+func (sess *session) handle(verb, arg string) *reply {
+	switch verb {
+	case "HELO":
+		sess.hello = true
+		sess.reset()
+		return &reply{250, sess.server.domain}
+	case "EHLO":
+		sess.hello = true
+		sess.reset()
+		lines := []string{sess.server.domain, "8BITMIME", fmt.Sprintf("SIZE %d", sess.server.maxSize)}
+		for _, line := range lines[:len(lines)-1] {
+			sess.text.PrintfLine("250-%s", line)
+		}
+		return &reply{250, lines[len(lines)-1]}
+	case "MAIL":
+		return sess.mail(arg)
+	case "RCPT":
+		return sess.rcpt(arg)
+	case "DATA":
+		return sess.data()
+	case "RSET":
+		sess.reset()
+		return &reply{250, "2.0.0 OK"}
+	case "NOOP":
+		return &reply{250, "2.0.0 OK"}
+	case "VRFY":
+		return &reply{252, "2.1.5 Cannot verify user"}
+	default:
+		return &reply{502, "5.5.2 Command not implemented"}
+	}
+}
+
+// mail starts a message transaction
+func (sess *session) mail(arg string) *reply {
+	if !sess.hello {
+		return &reply{503, "5.5.1 Say HELO first"}
+	}
+	if sess.hasFrom {
+		return &reply{503, "5.5.1 Sender already specified"}
+	}
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		return &reply{501, "5.5.4 Syntax: MAIL FROM:<address>"}
+	}
+	for _, param := range params {
+		if name, value, _ := strings.Cut(param, "="); strings.EqualFold(name, "SIZE") {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err == nil && size > sess.server.maxSize {
+				return &reply{552, "5.3.4 Message too large"}
+			}
+		}
+	}
+	sess.from, sess.hasFrom = from, true
+	return &reply{250, "2.1.0 OK"}
+}
+
+// rcpt adds a recipient to the message transaction, checking its room and token
+func (sess *session) rcpt(arg string) *reply {
+	if !sess.hasFrom {
+		return &reply{503, "5.5.1 Need MAIL first"}
+	}
+	if len(sess.recipients) >= maxRecipients {
+		return &reply{452, "4.5.3 Too many recipients"}
+	}
+	address, _, ok := parsePath(arg, "TO:")
+	if !ok {
+		return &reply{501, "5.5.4 Syntax: RCPT TO:<address>"}
+	}
+	recipient, err := sess.server.recipient(address)
+	if err != nil {
+		var r *reply
+		if errors.As(err, &r) {
+			return r
+		}
+		slog.Error("Unable to check email recipient", "recipient", address, "error", err)
+		return &reply{451, "4.3.0 Temporary failure"}
+	}
+	// A room named by several recipients receives the image once
+	if !slices.Contains(sess.recipients, recipient) {
+		sess.recipients = append(sess.recipients, recipient)
+	}
+	return &reply{250, "2.1.5 OK"}
+}
+
+// data receives a message and delivers it to the recipients
+func (sess *session) data() *reply {
+	if len(sess.recipients) == 0 {
+		return &reply{503, "5.5.1 Need RCPT first"}
+	}
+	sess.write(&reply{354, "Start mail input; end with <CRLF>.<CRLF>"})
+	sess.conn.SetDeadline(time.Now().Add(dataTimeout))
+
+	dot := sess.text.DotReader()
+	message, err := io.ReadAll(io.LimitReader(dot, sess.server.maxSize+1))
+	if err != nil {
+		sess.reset()
+		return &reply{451, "4.3.0 Unable to read message"}
+	}
+	defer sess.reset()
+	if int64(len(message)) > sess.server.maxSize {
+		io.Copy(io.Discard, dot)
+		return &reply{552, "5.3.4 Message too large"}
+	}
+
+	if err := sess.server.deliver(sess.recipients, sess.from, message); err != nil {
+		var r *reply
+		if errors.As(err, &r) {
+			return r
+		}
+		return &reply{451, "4.3.0 Unable to publish image"}
+	}
+	return &reply{250, "2.0.0 Image published"}
+}
+
+// reset aborts the current message transaction
+func (sess *session) reset() {
+	sess.from, sess.hasFrom, sess.recipients = "", false, nil
+}
+
+// write sends a reply
+func (sess *session) write(r *reply) {
+	sess.text.PrintfLine("%d %s", r.code, r.message)
+}
+
+// parsePath parses the "FROM:<address> PARAMS" or "TO:<address> PARAMS" argument of a command
+func parsePath(arg, prefix string) (string, []string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", nil, false
+	}
+	address, params, ok := strings.Cut(rest[1:], ">")
+	if !ok {
+		return "", nil, false
+	}
+	return address, strings.Fields(params), true
+}
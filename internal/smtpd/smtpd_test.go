@@ -0,0 +1,238 @@
+package smtpd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ncarlier/imgcast/internal/auth"
+	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/internal/storage"
+)
+
+// testServer is an SMTP server publishing to rooms stored in a temporary directory
+type testServer struct {
+	addr    string
+	store   *storage.Storage
+	manager *room.Manager
+}
+
+// newTestServer starts an SMTP server accepting images of at most maxImageSize bytes
+func newTestServer(t *testing.T, maxImageSize int64) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	store := storage.New(dir)
+	if err := store.EnsureBaseDir(); err != nil {
+		t.Fatal(err)
+	}
+	manager := room.NewManager(store, auth.NewAuthenticator(filepath.Join(dir, storage.HtpasswdFilename)), false, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go New(manager, "", maxImageSize).Serve(listener)
+	return &testServer{addr: listener.Addr().String(), store: store, manager: manager}
+}
+
+// room creates a room and returns an ingestion token of it
+func (s *testServer) room(t *testing.T, name string) string {
+	t.Helper()
+	if _, err := s.manager.ProvisionRoom(name, room.Options{Members: []room.Member{{Username: "alice", Password: "secret"}}}); err != nil {
+		t.Fatalf("ProvisionRoom: %v", err)
+	}
+	t.Cleanup(func() { s.manager.DeleteRoom(name) })
+	_, token, err := s.manager.CreateToken(name, "mail")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	return token
+}
+
+// send sends a message to recipients, returning the code of the first refusal (zero if accepted)
+func (s *testServer) send(t *testing.T, message []byte, recipients ...string) int {
+	t.Helper()
+	err := smtp.SendMail(s.addr, nil, "camera@example.com", recipients, message)
+	if err == nil {
+		return 0
+	}
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("SendMail: %v", err)
+	}
+	return protoErr.Code
+}
+
+// testPNG returns a small PNG image, padded with a text chunk to about size bytes
+func testPNG(t *testing.T, size int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if size <= len(data) {
+		return data
+	}
+	// A tEXt chunk is inserted before the IEND chunk, its CRC being ignored by the format detection
+	chunk := make([]byte, 12+size-len(data))
+	copy(chunk[4:], "tEXt")
+	iend := len(data) - 12
+	return append(append(append([]byte{}, data[:iend]...), chunk...), data[iend:]...)
+}
+
+// newMessage returns a message with a text part and an image attachment
+func newMessage(to string, attachment []byte) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: camera@example.com\r\nTo: %s\r\nSubject: snapshot\r\nMIME-Version: 1.0\r\n", to)
+	b.WriteString("Content-Type: multipart/mixed; boundary=\"BOUNDARY\"\r\n\r\n")
+	b.WriteString("--BOUNDARY\r\nContent-Type: text/plain\r\n\r\nNew snapshot\r\n")
+	if attachment != nil {
+		b.WriteString("--BOUNDARY\r\nContent-Type: image/png; name=\"snapshot.png\"\r\nContent-Transfer-Encoding: base64\r\n\r\n")
+		encoded := base64.StdEncoding.EncodeToString(attachment)
+		for len(encoded) > 76 {
+			b.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		b.WriteString(encoded + "\r\n")
+	}
+	b.WriteString("--BOUNDARY--\r\n")
+	return []byte(b.String())
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	s := newTestServer(t, 0)
+	token := s.room(t, "lobby")
+
+	tests := []struct {
+		name      string
+		recipient string
+		code      int
+	}{
+		{"valid token", "lobby+" + token + "@imgcast.local", 250},
+		{"domain case", "lobby+" + token + "@IMGCAST.local", 250},
+		{"invalid token", "lobby+nope@imgcast.local", 550},
+		{"missing token", "lobby@imgcast.local", 550},
+		{"unknown room", "attic+" + token + "@imgcast.local", 550},
+		{"other domain", "lobby+" + token + "@example.com", 550},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := smtp.Dial(s.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if err := client.Mail("camera@example.com"); err != nil {
+				t.Fatalf("MAIL: %v", err)
+			}
+			code := 250
+			if err := client.Rcpt(tt.recipient); err != nil {
+				var protoErr *textproto.Error
+				if !errors.As(err, &protoErr) {
+					t.Fatalf("RCPT: %v", err)
+				}
+				code = protoErr.Code
+			}
+			if code != tt.code {
+				t.Errorf("RCPT TO:<%s> = %d, want %d", tt.recipient, code, tt.code)
+			}
+		})
+	}
+}
+
+func TestAttachmentPublished(t *testing.T) {
+	s := newTestServer(t, 0)
+	lobby, hall := s.room(t, "lobby"), s.room(t, "hall")
+	snapshot := testPNG(t, 0)
+
+	if code := s.send(t, newMessage("lobby", nil), "lobby+"+lobby+"@imgcast.local"); code != 550 {
+		t.Errorf("message without image: code %d, want 550", code)
+	}
+	if s.store.HasImage("lobby", "") {
+		t.Fatal("image published from a message without image")
+	}
+
+	if code := s.send(t, newMessage("lobby", snapshot), "lobby+"+lobby+"@imgcast.local", "hall+"+hall+"@imgcast.local"); code != 0 {
+		t.Fatalf("message refused with code %d", code)
+	}
+	for _, name := range []string{"lobby", "hall"} {
+		if !s.store.HasImage(name, "") {
+			t.Errorf("image not published to %s", name)
+		}
+	}
+}
+
+func TestRecipientsCheckedBeforePublishing(t *testing.T) {
+	s := newTestServer(t, 0)
+	lobby, hall := s.room(t, "lobby"), s.room(t, "hall")
+
+	// The room of the second recipient disappears between RCPT and DATA
+	client, err := smtp.Dial(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Mail("camera@example.com"); err != nil {
+		t.Fatalf("MAIL: %v", err)
+	}
+	for _, recipient := range []string{"lobby+" + lobby + "@imgcast.local", "hall+" + hall + "@imgcast.local"} {
+		if err := client.Rcpt(recipient); err != nil {
+			t.Fatalf("RCPT: %v", err)
+		}
+	}
+	if err := s.manager.DeleteRoom("hall"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		t.Fatalf("DATA: %v", err)
+	}
+	w.Write(newMessage("lobby", testPNG(t, 0)))
+	var protoErr *textproto.Error
+	if err := w.Close(); !errors.As(err, &protoErr) || protoErr.Code != 550 {
+		t.Fatalf("message with a deleted room: error %v, want 550", err)
+	}
+	if s.store.HasImage("lobby", "") {
+		t.Error("image published although a recipient was refused")
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	const maxImageSize = 64 << 10
+	s := newTestServer(t, maxImageSize)
+	token := s.room(t, "lobby")
+	recipient := "lobby+" + token + "@imgcast.local"
+
+	client, err := smtp.Dial(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if ok, size := client.Extension("SIZE"); !ok || size == "" {
+		t.Errorf("SIZE extension not advertised")
+	}
+
+	if code := s.send(t, newMessage(recipient, testPNG(t, maxImageSize)), recipient); code != 0 {
+		t.Fatalf("image of the maximum size refused with code %d", code)
+	}
+	if code := s.send(t, newMessage(recipient, testPNG(t, 2*messageOverhead)), recipient); code != 552 {
+		t.Errorf("oversized message: code %d, want 552", code)
+	}
+}
+
+func TestSizeLimitWithoutImageLimit(t *testing.T) {
+	if s := New(nil, "", 0); s.maxSize != maxMessageSize {
+		t.Errorf("maxSize = %d, want %d", s.maxSize, maxMessageSize)
+	}
+}
@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 )
 
 const (
@@ -12,6 +17,30 @@ const (
 	LiveDataFilename = "imgcast.data"
 	// HtpasswdFilename is the name of the htpasswd file
 	HtpasswdFilename = ".htpasswd"
+	// SettingsFilename is the name of the room settings file
+	SettingsFilename = "settings.json"
+	// PlaylistFilename is the name of the room playlist file
+	PlaylistFilename = "playlist.json"
+	// PendingFilename is the name of the room scheduled publications file
+	PendingFilename = "pending.json"
+	// OriginalDataFilename is the name of the file where the image data is kept before its overlays are applied
+	OriginalDataFilename = "original.data"
+	// WatermarkFilename is the name of the room watermark image file
+	WatermarkFilename = "watermark.data"
+	// VersionFilename is the name of the file describing the current version of an image
+	VersionFilename = "version.json"
+	// MetadataReportFilename is the name of the report of the metadata removed from an image
+	MetadataReportFilename = "metadata.json"
+	// WebhooksFilename is the name of the room webhook subscriptions file
+	WebhooksFilename = "webhooks.json"
+	// WebhookDeliveriesFilename is the name of the room webhook delivery log
+	WebhookDeliveriesFilename = "webhook-deliveries.json"
+	// TokensFilename is the name of the room ingestion tokens file
+	TokensFilename = "tokens.json"
+	// HistoryFilename is the name of the room publication history file
+	HistoryFilename = "history.json"
+	// NamespaceHtpasswdFilename is the name of the htpasswd file of namespace admins
+	NamespaceHtpasswdFilename = ".admins.htpasswd"
 )
 
 // Storage handles file operations for rooms
@@ -27,8 +56,24 @@ func New(baseDir string) *Storage {
 }
 
 // GetRoomDir returns the directory path for a room
+// Room names may contain namespaces ("acme/marketing/lobby"), mirrored as nested directories
 func (s *Storage) GetRoomDir(roomName string) string {
-	return filepath.Join(s.baseDir, "rooms", roomName)
+	return filepath.Join(s.getRoomsDir(), filepath.FromSlash(roomName))
+}
+
+// GetNamespaceDir returns the directory path for a namespace
+func (s *Storage) GetNamespaceDir(namespace string) string {
+	return filepath.Join(s.getRoomsDir(), filepath.FromSlash(namespace))
+}
+
+// GetNamespaceHtpasswdPath returns the path to the namespace admins htpasswd file
+func (s *Storage) GetNamespaceHtpasswdPath(namespace string) string {
+	return filepath.Join(s.GetNamespaceDir(namespace), NamespaceHtpasswdFilename)
+}
+
+// getRoomsDir returns the directory containing all rooms and namespaces
+func (s *Storage) getRoomsDir() string {
+	return filepath.Join(s.baseDir, "rooms")
 }
 
 // GetRoomImagePath returns the path to the room's image file
@@ -36,25 +81,283 @@ func (s *Storage) GetRoomImagePath(roomName string) string {
 	return filepath.Join(s.GetRoomDir(roomName), LiveDataFilename)
 }
 
+// GetSlotImagePath returns the path to the image file of a named slot of a room
+// The empty slot designates the main room image
+func (s *Storage) GetSlotImagePath(roomName, slot string) string {
+	if slot == "" {
+		return s.GetRoomImagePath(roomName)
+	}
+	return filepath.Join(s.GetRoomDir(roomName), "slots", slot, LiveDataFilename)
+}
+
+// GetRenditionPath returns the path to a cached rendition of the image of a room slot
+// Renditions are stored next to the image they derive from.
+func (s *Storage) GetRenditionPath(roomName, slot, name string) string {
+	return filepath.Join(s.getRenditionsDir(roomName, slot), name)
+}
+
+// getRenditionsDir returns the directory holding the renditions of the image of a room slot
+func (s *Storage) getRenditionsDir(roomName, slot string) string {
+	return filepath.Join(filepath.Dir(s.GetSlotImagePath(roomName, slot)), "renditions")
+}
+
+// GetThumbnailPath returns the path to a thumbnail of the image of a room slot
+// The size is the maximum width and height of the thumbnail.
+func (s *Storage) GetThumbnailPath(roomName, slot string, size int) string {
+	return filepath.Join(s.getThumbnailsDir(roomName, slot), fmt.Sprintf("%d.jpg", size))
+}
+
+// getThumbnailsDir returns the directory holding the thumbnails of the image of a room slot
+func (s *Storage) getThumbnailsDir(roomName, slot string) string {
+	return filepath.Join(filepath.Dir(s.GetSlotImagePath(roomName, slot)), "thumbs")
+}
+
+// GetOriginalImagePath returns the path to the image of a room slot before its overlays were applied
+func (s *Storage) GetOriginalImagePath(roomName, slot string) string {
+	return filepath.Join(filepath.Dir(s.GetSlotImagePath(roomName, slot)), OriginalDataFilename)
+}
+
+// GetWatermarkPath returns the path to the room's watermark image
+func (s *Storage) GetWatermarkPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), WatermarkFilename)
+}
+
+// HasWatermark checks if a watermark image has been set for a room
+func (s *Storage) HasWatermark(roomName string) bool {
+	_, err := os.Stat(s.GetWatermarkPath(roomName))
+	return err == nil
+}
+
+// GetVersionPath returns the path to the description of the current version of the image of a room slot
+func (s *Storage) GetVersionPath(roomName, slot string) string {
+	return filepath.Join(filepath.Dir(s.GetSlotImagePath(roomName, slot)), VersionFilename)
+}
+
+// GetMetadataReportPath returns the path to the report of the metadata removed from the image of a room slot
+func (s *Storage) GetMetadataReportPath(roomName, slot string) string {
+	return filepath.Join(filepath.Dir(s.GetSlotImagePath(roomName, slot)), MetadataReportFilename)
+}
+
+// HasImage checks if an image has been uploaded to a slot of a room
+func (s *Storage) HasImage(roomName, slot string) bool {
+	_, err := os.Stat(s.GetSlotImagePath(roomName, slot))
+	return err == nil
+}
+
+// ListSlots returns the names of the named slots holding an image in a room
+func (s *Storage) ListSlots(roomName string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.GetRoomDir(roomName), "slots"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list slots: %w", err)
+	}
+
+	var slots []string
+	for _, entry := range entries {
+		if entry.IsDir() && s.HasImage(roomName, entry.Name()) {
+			slots = append(slots, entry.Name())
+		}
+	}
+	return slots, nil
+}
+
 // GetRoomHtpasswdPath returns the path to the room's htpasswd file
 func (s *Storage) GetRoomHtpasswdPath(roomName string) string {
 	return filepath.Join(s.GetRoomDir(roomName), HtpasswdFilename)
 }
 
+// GetRoomSettingsPath returns the path to the room's settings file
+func (s *Storage) GetRoomSettingsPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), SettingsFilename)
+}
+
+// GetRoomPlaylistPath returns the path to the room's playlist file
+func (s *Storage) GetRoomPlaylistPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), PlaylistFilename)
+}
+
+// GetPlaylistItemPath returns the path to the image of a playlist item
+func (s *Storage) GetPlaylistItemPath(roomName, id string) string {
+	return filepath.Join(s.GetRoomDir(roomName), "playlist", id+".data")
+}
+
+// GetRoomPendingPath returns the path to the room's scheduled publications file
+func (s *Storage) GetRoomPendingPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), PendingFilename)
+}
+
+// GetPendingImagePath returns the path to the image of a scheduled publication
+func (s *Storage) GetPendingImagePath(roomName, id string) string {
+	return filepath.Join(s.GetRoomDir(roomName), "pending", id+".data")
+}
+
+// GetRoomWebhooksPath returns the path to the room's webhook subscriptions file
+func (s *Storage) GetRoomWebhooksPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), WebhooksFilename)
+}
+
+// GetRoomWebhookDeliveriesPath returns the path to the room's webhook delivery log
+func (s *Storage) GetRoomWebhookDeliveriesPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), WebhookDeliveriesFilename)
+}
+
+// GetRoomTokensPath returns the path to the room's ingestion tokens file
+func (s *Storage) GetRoomTokensPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), TokensFilename)
+}
+
+// GetRoomHistoryPath returns the path to the room's publication history file
+func (s *Storage) GetRoomHistoryPath(roomName string) string {
+	return filepath.Join(s.GetRoomDir(roomName), HistoryFilename)
+}
+
+// GetHistoryImagePath returns the path to the image of a publication history entry
+func (s *Storage) GetHistoryImagePath(roomName, id string) string {
+	return filepath.Join(s.GetRoomDir(roomName), "history", id+".data")
+}
+
 // GetAdminHtpasswdPath returns the path to the admin htpasswd file
 func (s *Storage) GetAdminHtpasswdPath() string {
 	return filepath.Join(s.baseDir, HtpasswdFilename)
 }
 
-// RoomExists checks if a room directory exists
+// RoomExists checks if a room exists
+// A room is a directory holding a room htpasswd file
 func (s *Storage) RoomExists(roomName string) bool {
 	if roomName == "" {
 		return false
 	}
-	_, err := os.Stat(s.GetRoomDir(roomName))
+	_, err := os.Stat(s.GetRoomHtpasswdPath(roomName))
 	return err == nil
 }
 
+// NamespaceExists checks if a namespace exists
+// A namespace is a directory holding a namespace admins htpasswd file
+func (s *Storage) NamespaceExists(namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	_, err := os.Stat(s.GetNamespaceHtpasswdPath(namespace))
+	return err == nil
+}
+
+// ListRooms returns the names of all rooms found on disk, namespaces included
+func (s *Storage) ListRooms() ([]string, error) {
+	return s.ListRoomsIn("")
+}
+
+// ListRoomsIn returns the names of all rooms found beneath a namespace (or all rooms if empty)
+func (s *Storage) ListRoomsIn(namespace string) ([]string, error) {
+	roomsDir := s.getRoomsDir()
+
+	var rooms []string
+	err := filepath.WalkDir(s.GetNamespaceDir(namespace), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == roomsDir {
+			return nil
+		}
+		if d.Name() != strings.ToLower(d.Name()) {
+			// Directories with upper case letters cannot be reached by lower case room paths
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(path, HtpasswdFilename)); err != nil {
+			// Not a room, possibly a namespace
+			return nil
+		}
+		name, err := filepath.Rel(roomsDir, path)
+		if err != nil {
+			return err
+		}
+		rooms = append(rooms, filepath.ToSlash(name))
+		// Rooms do not contain other rooms
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rooms: %w", err)
+	}
+	return rooms, nil
+}
+
+// LowercaseRoomDirs renames the room and namespace directories whose name holds upper case letters,
+// created before room paths were case-insensitive, to their lower case name
+// It returns the renamed room paths by their former path, and the paths left in place because their
+// lower case name is already taken.
+func (s *Storage) LowercaseRoomDirs() (map[string]string, []string, error) {
+	renamed := make(map[string]string)
+	var conflicts []string
+	if err := s.lowercaseDir(s.getRoomsDir(), renamed, &conflicts); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("failed to rename room directories: %w", err)
+	}
+	return renamed, conflicts, nil
+}
+
+// lowercaseDir renames the subdirectories of a namespace directory to their lower case name, recursively
+func (s *Storage) lowercaseDir(dir string, renamed map[string]string, conflicts *[]string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	roomsDir := s.getRoomsDir()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if lower := strings.ToLower(entry.Name()); lower != entry.Name() {
+			target := filepath.Join(dir, lower)
+			if _, err := os.Stat(target); err == nil {
+				from, _ := filepath.Rel(roomsDir, path)
+				*conflicts = append(*conflicts, filepath.ToSlash(from))
+				continue
+			}
+			if err := os.Rename(path, target); err != nil {
+				return err
+			}
+			from, _ := filepath.Rel(roomsDir, path)
+			to, _ := filepath.Rel(roomsDir, target)
+			renamed[filepath.ToSlash(from)] = filepath.ToSlash(to)
+			path = target
+		}
+		// Rooms do not contain other rooms
+		if _, err := os.Stat(filepath.Join(path, HtpasswdFilename)); err == nil {
+			continue
+		}
+		if err := s.lowercaseDir(path, renamed, conflicts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastUpdate returns the time of the last image update of a room, all slots included
+// The room creation time is used if no image has been uploaded yet
+func (s *Storage) LastUpdate(roomName string) (time.Time, error) {
+	info, err := os.Stat(s.GetRoomHtpasswdPath(roomName))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get room last update: %w", err)
+	}
+	lastUpdate := time.Time{}
+
+	slots, err := s.ListSlots(roomName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, slot := range append([]string{""}, slots...) {
+		if info, err := os.Stat(s.GetSlotImagePath(roomName, slot)); err == nil && info.ModTime().After(lastUpdate) {
+			lastUpdate = info.ModTime()
+		}
+	}
+
+	if lastUpdate.IsZero() {
+		lastUpdate = info.ModTime()
+	}
+	return lastUpdate, nil
+}
+
 // CreateRoom creates a new room directory
 func (s *Storage) CreateRoom(roomName string) error {
 	roomDir := s.GetRoomDir(roomName)
@@ -64,15 +367,184 @@ func (s *Storage) CreateRoom(roomName string) error {
 	return nil
 }
 
-// SaveImage saves an image to the room's storage
-func (s *Storage) SaveImage(roomName string, reader io.Reader) error {
-	imagePath := s.GetRoomImagePath(roomName)
+// CreateNamespace creates a new namespace directory
+func (s *Storage) CreateNamespace(namespace string) error {
+	if err := os.MkdirAll(s.GetNamespaceDir(namespace), 0755); err != nil {
+		return fmt.Errorf("failed to create namespace directory: %w", err)
+	}
+	return nil
+}
+
+// DeleteNamespace removes a namespace directory and all its content
+func (s *Storage) DeleteNamespace(namespace string) error {
+	if namespace == "" {
+		return fmt.Errorf("namespace required")
+	}
+	if err := os.RemoveAll(s.GetNamespaceDir(namespace)); err != nil {
+		return fmt.Errorf("failed to delete namespace directory: %w", err)
+	}
+	return nil
+}
+
+// DeleteRoom removes a room directory and all its content
+func (s *Storage) DeleteRoom(roomName string) error {
+	if roomName == "" {
+		return fmt.Errorf("room name required")
+	}
+	if err := os.RemoveAll(s.GetRoomDir(roomName)); err != nil {
+		return fmt.Errorf("failed to delete room directory: %w", err)
+	}
+	return nil
+}
+
+// SaveImage saves an image to a slot of the room's storage (the empty slot being the main room image)
+// The image is replaced atomically, so that viewers never get a partial image.
+func (s *Storage) SaveImage(roomName, slot string, reader io.Reader) error {
+	return saveFileAtomic(s.GetSlotImagePath(roomName, slot), reader)
+}
+
+// SavePlaylistItem saves the image of a playlist item
+func (s *Storage) SavePlaylistItem(roomName, id string, reader io.Reader) error {
+	return saveFile(s.GetPlaylistItemPath(roomName, id), reader)
+}
+
+// SaveRendition stores a rendition of the image of a room slot
+func (s *Storage) SaveRendition(roomName, slot, name string, reader io.Reader) error {
+	return saveFileAtomic(s.GetRenditionPath(roomName, slot, name), reader)
+}
+
+// PruneRenditions removes the least recently generated renditions of the image of a room slot beyond the given
+// number
+func (s *Storage) PruneRenditions(roomName, slot string, keep int) error {
+	entries, err := os.ReadDir(s.getRenditionsDir(roomName, slot))
+	if err != nil {
+		return fmt.Errorf("failed to list renditions: %w", err)
+	}
+	if len(entries) <= keep {
+		return nil
+	}
+
+	type rendition struct {
+		path    string
+		modTime time.Time
+	}
+	renditions := make([]rendition, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		renditions = append(renditions, rendition{filepath.Join(s.getRenditionsDir(roomName, slot), entry.Name()), info.ModTime()})
+	}
+	slices.SortFunc(renditions, func(a, b rendition) int { return b.modTime.Compare(a.modTime) })
+	for _, r := range renditions[min(keep, len(renditions)):] {
+		if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete rendition: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeleteRenditions removes the cached renditions of the image of a room slot
+func (s *Storage) DeleteRenditions(roomName, slot string) error {
+	if err := os.RemoveAll(s.getRenditionsDir(roomName, slot)); err != nil {
+		return fmt.Errorf("failed to delete renditions: %w", err)
+	}
+	return nil
+}
+
+// SaveThumbnail stores a thumbnail of the image of a room slot
+func (s *Storage) SaveThumbnail(roomName, slot string, size int, reader io.Reader) error {
+	return saveFileAtomic(s.GetThumbnailPath(roomName, slot, size), reader)
+}
+
+// DeleteThumbnails removes the thumbnails of the image of a room slot
+func (s *Storage) DeleteThumbnails(roomName, slot string) error {
+	if err := os.RemoveAll(s.getThumbnailsDir(roomName, slot)); err != nil {
+		return fmt.Errorf("failed to delete thumbnails: %w", err)
+	}
+	return nil
+}
+
+// SaveOriginalImage saves the image of a room slot before its overlays are applied
+func (s *Storage) SaveOriginalImage(roomName, slot string, reader io.Reader) error {
+	return saveFile(s.GetOriginalImagePath(roomName, slot), reader)
+}
+
+// DeleteOriginalImage removes the image of a room slot saved before its overlays were applied
+func (s *Storage) DeleteOriginalImage(roomName, slot string) error {
+	if err := os.Remove(s.GetOriginalImagePath(roomName, slot)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete original image: %w", err)
+	}
+	return nil
+}
+
+// SaveWatermark saves the room's watermark image
+func (s *Storage) SaveWatermark(roomName string, reader io.Reader) error {
+	return saveFile(s.GetWatermarkPath(roomName), reader)
+}
+
+// DeleteWatermark removes the room's watermark image
+func (s *Storage) DeleteWatermark(roomName string) error {
+	if err := os.Remove(s.GetWatermarkPath(roomName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete watermark: %w", err)
+	}
+	return nil
+}
+
+// DeleteMetadataReport removes the metadata report of the image of a room slot
+func (s *Storage) DeleteMetadataReport(roomName, slot string) error {
+	if err := os.Remove(s.GetMetadataReportPath(roomName, slot)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete metadata report: %w", err)
+	}
+	return nil
+}
+
+// DeletePlaylistItem removes the image of a playlist item
+func (s *Storage) DeletePlaylistItem(roomName, id string) error {
+	if err := os.Remove(s.GetPlaylistItemPath(roomName, id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete playlist item: %w", err)
+	}
+	return nil
+}
+
+// SavePendingImage saves the image of a scheduled publication
+func (s *Storage) SavePendingImage(roomName, id string, reader io.Reader) error {
+	return saveFile(s.GetPendingImagePath(roomName, id), reader)
+}
+
+// DeletePendingImage removes the image of a scheduled publication
+func (s *Storage) DeletePendingImage(roomName, id string) error {
+	if err := os.Remove(s.GetPendingImagePath(roomName, id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete pending image: %w", err)
+	}
+	return nil
+}
+
+// SaveHistoryImage saves the image of a publication history entry
+func (s *Storage) SaveHistoryImage(roomName, id string, reader io.Reader) error {
+	return saveFile(s.GetHistoryImagePath(roomName, id), reader)
+}
+
+// DeleteHistoryImage removes the image of a publication history entry
+func (s *Storage) DeleteHistoryImage(roomName, id string) error {
+	if err := os.Remove(s.GetHistoryImagePath(roomName, id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete history image: %w", err)
+	}
+	return nil
+}
+
+// saveFile replaces the content of a file with the data of a reader, creating parent directories if needed
+func saveFile(path string, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
 
 	// Remove old file if it exists
-	os.Remove(imagePath)
+	os.Remove(path)
 
 	// Create the image file
-	file, err := os.Create(imagePath)
+	file, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("failed to create image file: %w", err)
 	}
@@ -86,6 +558,33 @@ func (s *Storage) SaveImage(roomName string, reader io.Reader) error {
 	return nil
 }
 
+// saveFileAtomic replaces the content of a file with the data of a reader, through a temporary file
+// Concurrent readers see either the previous content or the complete new one.
+func saveFileAtomic(path string, reader io.Reader) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to store file: %w", err)
+	}
+	return nil
+}
+
 // EnsureBaseDir ensures the base directory structure exists
 func (s *Storage) EnsureBaseDir() error {
 	// Create base directory
@@ -94,7 +593,7 @@ func (s *Storage) EnsureBaseDir() error {
 	}
 
 	// Create rooms directory
-	roomsDir := filepath.Join(s.baseDir, "rooms")
+	roomsDir := s.getRoomsDir()
 	if err := os.MkdirAll(roomsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create rooms directory: %w", err)
 	}
@@ -4,12 +4,19 @@ import (
 	"embed"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
 
 	"github.com/ncarlier/imgcast/internal/auth"
+	"github.com/ncarlier/imgcast/internal/cli"
 	"github.com/ncarlier/imgcast/internal/config"
+	"github.com/ncarlier/imgcast/internal/fetch"
 	"github.com/ncarlier/imgcast/internal/handlers"
+	"github.com/ncarlier/imgcast/internal/hook"
+	"github.com/ncarlier/imgcast/internal/mqtt"
 	"github.com/ncarlier/imgcast/internal/room"
+	"github.com/ncarlier/imgcast/internal/smtpd"
 	"github.com/ncarlier/imgcast/internal/storage"
 )
 
@@ -17,6 +24,11 @@ import (
 var staticFS embed.FS
 
 func main() {
+	// Run command-line subcommand if any
+	if len(os.Args) > 1 {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
+
 	// Load configuration
 	cfg := config.Load()
 	slog.Info("Configuration loaded", "port", cfg.Port, "basePath", cfg.BasePath, "roomsBaseDir", cfg.RoomsBaseDir)
@@ -36,7 +48,86 @@ func main() {
 	}
 
 	// Initialize room manager
-	roomManager := room.NewManager(store, adminAuth)
+	roomManager := room.NewManager(store, adminAuth, cfg.AutoCreateRooms, cfg.ThumbnailSizes)
+	if err := roomManager.MigrateRoomNames(); err != nil {
+		log.Fatal("Failed to migrate room names:", err)
+	}
+	if err := roomManager.CheckRoomNames(); err != nil {
+		log.Fatal("Failed to check room names:", err)
+	}
+
+	// Load the pre-publish hooks, if any
+	if cfg.PublishHooks != "" {
+		hooks, err := hook.Load(cfg.PublishHooks)
+		if err != nil {
+			log.Fatal("Failed to load publish hooks:", err)
+		}
+		roomManager.SetHooks(hooks)
+		slog.Info("Publish hooks loaded", "path", cfg.PublishHooks, "count", hooks.Len())
+	}
+
+	// Resume playlists that were playing before the server stopped, and scheduled publications
+	roomManager.ResumePlaylists()
+	roomManager.ResumePublications()
+
+	// Start garbage collection of expired and abandoned rooms
+	roomManager.StartJanitor(room.GCPolicy{
+		MaxIdle:  cfg.RoomMaxIdle,
+		Interval: cfg.RoomGCInterval,
+		DryRun:   cfg.RoomGCDryRun,
+	})
+
+	// Deliver the webhooks, to public addresses and to the allowed internal networks
+	webhookNetworks, err := fetch.ParseNetworks(cfg.WebhookNetworks)
+	if err != nil {
+		log.Fatal("Invalid WEBHOOK_ALLOWED_NETWORKS:", err)
+	}
+	roomManager.SetWebhookFetcher(fetch.New(fetch.Options{
+		Timeout:         cfg.FetchTimeout,
+		AllowedNetworks: webhookNetworks,
+	}))
+
+	// Start pulling the room sources
+	roomManager.SetFetcher(fetch.New(fetch.Options{
+		Timeout:      cfg.FetchTimeout,
+		MaxSize:      cfg.MaxUploadSize,
+		AllowPrivate: cfg.FetchPrivate,
+	}))
+	roomManager.StartSources()
+
+	// Start the SMTP server receiving images by email, if enabled
+	if cfg.SMTPAddr != "" {
+		listener, err := net.Listen("tcp", cfg.SMTPAddr)
+		if err != nil {
+			log.Fatal("Failed to start SMTP server:", err)
+		}
+		slog.Info("Starting SMTP server", "addr", cfg.SMTPAddr, "domain", cfg.SMTPDomain)
+		go func() {
+			log.Fatal(smtpd.New(roomManager, cfg.SMTPDomain, cfg.MaxUploadSize).Serve(listener))
+		}()
+	}
+
+	// Connect to the MQTT broker, if enabled
+	if cfg.MQTTBroker != "" {
+		topics, err := mqtt.ParseTopics(cfg.MQTTTopics)
+		if err != nil {
+			log.Fatal("Failed to parse MQTT topics:", err)
+		}
+		bridge := mqtt.New(roomManager, mqtt.Options{
+			Broker:      cfg.MQTTBroker,
+			ClientID:    cfg.MQTTClientID,
+			Username:    cfg.MQTTUsername,
+			Password:    cfg.MQTTPassword,
+			Topics:      topics,
+			EventsTopic: cfg.MQTTEventsTopic,
+			QoS:         byte(cfg.MQTTQoS),
+			MaxSize:     cfg.MaxUploadSize,
+		})
+		if err := bridge.Start(); err != nil {
+			log.Fatal("Failed to connect to MQTT broker:", err)
+		}
+		slog.Info("MQTT bridge started", "broker", cfg.MQTTBroker, "topics", len(topics))
+	}
 
 	// Initialize HTTP server
 	server, err := handlers.NewServer(cfg, roomManager, store, staticFS)
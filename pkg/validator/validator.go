@@ -1,11 +1,116 @@
 package validator
 
-import "regexp"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
 
-var roomNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+const (
+	// MaxRoomNameLength is the maximum length of a room name or of a namespace path segment
+	MaxRoomNameLength = 64
+	// MaxRoomDepth is the maximum number of segments of a room path (namespaces included)
+	MaxRoomDepth = 4
+)
+
+var (
+	// ErrInvalidRoomName is the error wrapped by all room name validation errors
+	ErrInvalidRoomName = errors.New("invalid room name")
+	// ErrInvalidSlotName is the error wrapped by all slot name validation errors
+	ErrInvalidSlotName = errors.New("invalid slot name")
+)
+
+var roomNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ReservedRoomNames are names that cannot be used as room names because they conflict with routes
+// The first segment of every room route action must be listed, which is checked when routes are registered.
+var ReservedRoomNames = []string{
+	"api",
+	"upload",
+	"live",
+	"thumb",
+	"events",
+	"settings",
+	"slots",
+	"playlist",
+	"pending",
+	"metadata",
+	"watermark",
+	"original",
+	"source",
+	"webhooks",
+	"tokens",
+	"dav",
+	"static",
+	"favicon",
+	"index",
+	"admin",
+}
+
+// NormalizeRoomName returns the canonical form of a room name
+// Room names are case-insensitive and stored in lower case
+func NormalizeRoomName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// NormalizeRoomPath returns the canonical form of a room path such as "acme/marketing/lobby"
+func NormalizeRoomPath(path string) string {
+	return strings.Trim(NormalizeRoomName(path), "/")
+}
+
+// ValidateRoomPath checks if a normalized room path is valid and returns the reason if not
+// A room path is a room name optionally prefixed by namespaces, each segment being a valid room name
+func ValidateRoomPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRoomName)
+	}
+
+	segments := strings.Split(path, "/")
+	if len(segments) > MaxRoomDepth {
+		return fmt.Errorf("%w: must have at most %d levels", ErrInvalidRoomName, MaxRoomDepth)
+	}
+	for _, segment := range segments {
+		if err := ValidateRoomName(segment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateRoomName checks if a normalized room name is valid and returns the reason if not
+// Room names must start with a lowercase alphanumeric character, followed by alphanumeric characters, dashes and underscores
+func ValidateRoomName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidRoomName)
+	case len(name) > MaxRoomNameLength:
+		return fmt.Errorf("%w: must be at most %d characters", ErrInvalidRoomName, MaxRoomNameLength)
+	case !roomNameRegex.MatchString(name):
+		return fmt.Errorf("%w: must start with a letter or digit and contain only letters, digits, dashes and underscores", ErrInvalidRoomName)
+	case slices.Contains(ReservedRoomNames, name):
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidRoomName, name)
+	}
+	return nil
+}
 
 // IsValidRoomName checks if a room name is valid
 // Room names must be alphanumeric with dash and underscore allowed
 func IsValidRoomName(name string) bool {
-	return roomNameRegex.MatchString(name)
+	return ValidateRoomName(NormalizeRoomName(name)) == nil
+}
+
+// ValidateSlotName checks if a slot name is valid and returns the reason if not
+// Slot names follow the room name rules, without reserved names
+func ValidateSlotName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidSlotName)
+	case len(name) > MaxRoomNameLength:
+		return fmt.Errorf("%w: must be at most %d characters", ErrInvalidSlotName, MaxRoomNameLength)
+	case !roomNameRegex.MatchString(name):
+		return fmt.Errorf("%w: must start with a letter or digit and contain only letters, digits, dashes and underscores", ErrInvalidSlotName)
+	}
+	return nil
 }